@@ -0,0 +1,174 @@
+package timing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChildShare is the share of a parent's total child time that is spent in a single child.
+type ChildShare struct {
+	// Name is the name of the child location.
+	Name string
+
+	// Duration is the total duration of the child.
+	Duration time.Duration
+
+	// Fraction is the fraction of the total child duration that this child accounts for.
+	Fraction float64
+
+	// Cumulative is the fraction of the total child duration that this child and all the children
+	// that are slower than it account for.
+	Cumulative float64
+}
+
+// ChildDurationCDF returns the children of this location sorted by their duration, slowest first,
+// along with the fraction of the total child time that each one accounts for, both individually
+// and cumulatively. This is useful for Pareto-style analysis, for instance to see how much of the
+// time is spent in the slowest three children. Children with the same duration are kept in the
+// order they were called in. If the children have no time recorded, all the fractions are zero.
+func (l *Location) ChildDurationCDF() []ChildShare {
+	children := l.snapshotChildren()
+	shares := make([]ChildShare, 0, len(children))
+	for _, c := range children {
+		shares = append(shares, ChildShare{
+			Name:     c.Name,
+			Duration: c.Duration(),
+		})
+	}
+	sort.SliceStable(shares, func(i, j int) bool {
+		return shares[i].Duration > shares[j].Duration
+	})
+
+	var total time.Duration
+	for _, share := range shares {
+		total += share.Duration
+	}
+	if total <= 0 {
+		return shares
+	}
+	cumulative := time.Duration(0)
+	for i := range shares {
+		cumulative += shares[i].Duration
+		shares[i].Fraction = float64(shares[i].Duration) / float64(total)
+		shares[i].Cumulative = float64(cumulative) / float64(total)
+	}
+	return shares
+}
+
+// LocationSummary is a single location in the output of TopN.
+type LocationSummary struct {
+	// Path is the path of the location, as it is shown in the reports, e.g. "root > child".
+	Path string
+
+	// Duration is the reported duration of the location.
+	Duration time.Duration
+
+	// Calls is the number of times the location was entered.
+	Calls uint64
+}
+
+// TopN returns the n slowest locations anywhere in the tree, slowest first, regardless of where they
+// are in the hierarchy. With excludeChildren, the locations are ranked by their self-time, which is
+// their duration less that of their children unless they are Async, the same as the ExcludeChildren
+// report option. This is a quick way to find the hot spots of a large tree. Locations that have not
+// been entered are left out, and locations with the same duration are kept in call order. If n is zero
+// or less, all the locations are returned.
+func (l *Location) TopN(n int, excludeChildren bool) []LocationSummary {
+	options := &ReportOptions{ExcludeChildren: excludeChildren}
+	var result []LocationSummary
+	var walk func(loc *Location, path string)
+	walk = func(loc *Location, path string) {
+		if loc.Name != "" {
+			path += loc.effectiveName()
+			if loc.Entries() > 0 {
+				result = append(result, LocationSummary{
+					Path:     path,
+					Duration: loc.reportedDuration(options),
+					Calls:    loc.Entries(),
+				})
+			}
+			path += defaultSeparator
+		}
+		for _, c := range loc.snapshotChildren() {
+			walk(c, path)
+		}
+	}
+	walk(l, "")
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Duration > result[j].Duration
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// EffectiveParallelism returns the average concurrency that was achieved by the children of this
+// location. This is the total duration of the children divided by the duration of this location,
+// so 1.0 means that the children effectively ran one after the other and higher values mean that
+// they really overlapped. This is mainly meaningful for Async locations. If this location has no
+// duration, this returns 0.
+func (l *Location) EffectiveParallelism() float64 {
+	d := l.Duration()
+	if d <= 0 {
+		return 0
+	}
+	return float64(l.TotalChildDuration()) / float64(d)
+}
+
+// suspiciousChildRatio is the number of times a child has to be entered, per entry of its parent,
+// before the InstrumentationReport flags it.
+const suspiciousChildRatio = 100
+
+// InstrumentationReport generates a diagnostic report about the instrumentation itself rather than
+// about where the time went. For every location it shows how often it was entered and exited, how
+// many distinct children it has, and how often those children were entered in total. Locations
+// whose children are entered far more often than the location itself are flagged, since that
+// usually means a loop is being timed one iteration at a time where timing the whole loop as a
+// single child would be clearer and cheaper. Locations that were entered more often than they
+// were exited are flagged as well.
+func (l *Location) InstrumentationReport() string {
+	b := strings.Builder{}
+	l.dumpInstrumentation(&b, "")
+	return b.String()
+}
+
+// dumpInstrumentation recursively writes the instrumentation diagnostics of each location.
+func (l *Location) dumpInstrumentation(b *strings.Builder, path string) {
+	children := l.snapshotChildren()
+	childPrefix := path
+	if l.Name != "" {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(path)
+		b.WriteString(l.Name)
+
+		childEntries := uint64(0)
+		for _, c := range children {
+			childEntries += c.Entries()
+		}
+		b.WriteString(fmt.Sprintf(" - entries: %d exits: %d children: %d child entries: %d",
+			l.Entries(), l.Exits(), len(children), childEntries))
+
+		if l.Entries() != l.Exits() {
+			b.WriteString(" [incomplete]")
+		}
+		entries := l.Entries()
+		if entries == 0 {
+			entries = 1
+		}
+		for _, c := range children {
+			ratio := c.Entries() / entries
+			if ratio >= suspiciousChildRatio {
+				b.WriteString(fmt.Sprintf(" [%s entered %dx per entry, is this a loop?]", c.Name, ratio))
+			}
+		}
+		childPrefix = path + l.Name + defaultSeparator
+	}
+	for _, c := range children {
+		c.dumpInstrumentation(b, childPrefix)
+	}
+}
@@ -0,0 +1,63 @@
+package timing
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// budgetKey is the context key that holds the budget set by WithBudget.
+const budgetKey contextTimingType = 2
+
+// budget is a time budget that is used up by the timings that are completed under it.
+type budget struct {
+	// spent is the total duration of the completed timings. It comes first so that it is 64-bit
+	// aligned for the atomic operations on 32-bit platforms.
+	spent int64
+
+	total time.Duration
+
+	// base is the location that the budget was set under. Only the timings that are direct children
+	// of it use up the budget, so that the time of nested timings isn't counted more than once. This
+	// is nil if there was no timing context when the budget was set.
+	base *Location
+}
+
+// WithBudget returns a context that carries a time budget of total. Every timing that is started
+// under it with Start, StartAsync, or StartRoot uses up the budget by its duration when it is
+// completed. Only the timings at the level the budget was set at count, since the time of the timings
+// nested within them is already part of their duration. Use RemainingBudget to see how much of the
+// budget is left, e.g. to decide whether there is enough time left for an optional stage.
+func WithBudget(ctx context.Context, total time.Duration) context.Context {
+	b := &budget{total: total}
+	if p := findParentTiming(ctx); p != nil {
+		b.base = p.Location
+	}
+	return context.WithValue(ctx, budgetKey, b)
+}
+
+// RemainingBudget returns how much of the budget that was set with WithBudget is left. This becomes
+// negative once the budget has been overspent. If no budget was set, the budget is unlimited and the
+// largest possible duration is returned.
+func RemainingBudget(ctx context.Context) time.Duration {
+	b, _ := ctx.Value(budgetKey).(*budget)
+	if b == nil {
+		return time.Duration(math.MaxInt64)
+	}
+	return b.total - time.Duration(atomic.LoadInt64(&b.spent))
+}
+
+// budgetFor returns the budget that the completion of the location should be charged to, if any.
+func budgetFor(ctx context.Context, l *Location) *budget {
+	b, _ := ctx.Value(budgetKey).(*budget)
+	if b == nil || l.parent != b.base {
+		return nil
+	}
+	return b
+}
+
+// charge uses up the budget by the duration of a completed timing.
+func (b *budget) charge(d time.Duration) {
+	atomic.AddInt64(&b.spent, int64(d))
+}
@@ -0,0 +1,57 @@
+package timing
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func Test_Budget(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	ctx := WithBudget(rootCtx, 100*time.Millisecond)
+	assert.Equal(t, 100*time.Millisecond, RemainingBudget(ctx))
+
+	_, complete := Start(ctx, "stage 1")
+	clock = clock.Add(30 * time.Millisecond)
+	complete()
+	assert.Equal(t, 70*time.Millisecond, RemainingBudget(ctx))
+
+	// The nested timing is part of stage 2, so it is only counted once.
+	stageCtx, complete := Start(ctx, "stage 2")
+	clock = clock.Add(20 * time.Millisecond)
+	_, nestedComplete := Start(stageCtx, "nested")
+	clock = clock.Add(20 * time.Millisecond)
+	nestedComplete()
+	assert.Equal(t, 70*time.Millisecond, RemainingBudget(stageCtx))
+	complete()
+	assert.Equal(t, 30*time.Millisecond, RemainingBudget(stageCtx))
+
+	_, complete = Start(ctx, "stage 3")
+	clock = clock.Add(50 * time.Millisecond)
+	complete()
+	assert.Equal(t, -20*time.Millisecond, RemainingBudget(ctx))
+
+	rootComplete()
+	assert.Equal(t, -20*time.Millisecond, RemainingBudget(ctx))
+}
+
+func Test_BudgetWithoutTiming(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	assert.Equal(t, time.Duration(math.MaxInt64), RemainingBudget(context.Background()))
+
+	ctx := WithBudget(context.Background(), time.Second)
+	rootCtx, complete := Start(ctx, "root")
+	clock = clock.Add(200 * time.Millisecond)
+	_, childComplete := Start(rootCtx, "child")
+	clock = clock.Add(100 * time.Millisecond)
+	childComplete()
+	complete()
+	assert.Equal(t, 700*time.Millisecond, RemainingBudget(ctx))
+}
@@ -0,0 +1,66 @@
+package timing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// trackCancellationKey is the context key that is set by WithCancellationTracking.
+const trackCancellationKey contextTimingType = 3
+
+// WithCancellationTracking returns a context under which the timings that are started with Start,
+// StartAsync, or StartRoot are checked for having been cut short when they are completed. A call that
+// is completed after its context was cancelled, or after its deadline passed, is counted so that calls
+// that were aborted can be told apart from ones that were merely slow. See Cancelled and
+// DeadlineExceeded. This is off by default since it costs an allocation for every timing.
+func WithCancellationTracking(ctx context.Context) context.Context {
+	return context.WithValue(ctx, trackCancellationKey, true)
+}
+
+// tracksCancellation returns true if the timings started under the context are to be checked for
+// having been cut short.
+func tracksCancellation(ctx context.Context) bool {
+	track, _ := ctx.Value(trackCancellationKey).(bool)
+	return track && ctx.Done() != nil
+}
+
+// recordCancellation counts a call that was completed after its context was done.
+func recordCancellation(ctx context.Context, l *Location) {
+	switch ctx.Err() {
+	case nil:
+	case context.DeadlineExceeded:
+		atomic.AddUint64(&l.deadlineExceeded, 1)
+	default:
+		atomic.AddUint64(&l.cancelled, 1)
+	}
+}
+
+// Cancelled returns the number of calls of this location that were completed after their context was
+// cancelled. These are only counted under WithCancellationTracking.
+func (l *Location) Cancelled() uint64 {
+	return atomic.LoadUint64(&l.cancelled)
+}
+
+// DeadlineExceeded returns the number of calls of this location that were completed after the
+// deadline of their context passed. These are only counted under WithCancellationTracking.
+func (l *Location) DeadlineExceeded() uint64 {
+	return atomic.LoadUint64(&l.deadlineExceeded)
+}
+
+// formatCancellations annotates the location with the number of its calls that were cut short, e.g.
+// " [cancelled: 2, deadline exceeded: 1]".
+func (l *Location) formatCancellations() string {
+	var parts []string
+	if n := l.Cancelled(); n > 0 {
+		parts = append(parts, fmt.Sprintf("cancelled: %d", n))
+	}
+	if n := l.DeadlineExceeded(); n > 0 {
+		parts = append(parts, fmt.Sprintf("deadline exceeded: %d", n))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(parts, ", ") + "]"
+}
@@ -0,0 +1,65 @@
+package timing
+
+import (
+	"encoding/json"
+)
+
+// chromeTraceEvent is a complete ("X") event of the Chrome Trace Event Format.
+type chromeTraceEvent struct {
+	Name  string              `json:"name"`
+	Phase string              `json:"ph"`
+	TS    float64             `json:"ts"`
+	Dur   float64             `json:"dur"`
+	PID   int                 `json:"pid"`
+	TID   int                 `json:"tid"`
+	Args  map[string]anything `json:"args,omitempty"`
+}
+
+// ChromeTraceJSON generates the timing tree in the Chrome Trace Event Format, which can be loaded
+// into chrome://tracing or Perfetto to explore it as a flame chart. Each location is a complete event
+// with its details, and its entry count as "entries", as the arguments.
+//
+// Since only the total duration of each location is known, not when it was called, the events are
+// laid out from the durations: the tree starts at 0, and the children of a location follow one
+// another from the start of their parent in the order that they were called. The children of an Async
+// location all start at the start of their parent instead, and each is put on a separate track so that
+// their overlap renders correctly. The trace is built from a Clone of the tree, so this is safe to
+// call while it is still being timed.
+func (l *Location) ChromeTraceJSON() ([]byte, error) {
+	trace := struct {
+		TraceEvents []chromeTraceEvent `json:"traceEvents"`
+	}{
+		TraceEvents: []chromeTraceEvent{},
+	}
+	tracks := 1
+	var walk func(n *Location, start float64, tid int)
+	walk = func(n *Location, start float64, tid int) {
+		if n.Name != "" {
+			args := map[string]anything{"entries": n.EntryCount}
+			for k, v := range n.Details {
+				args[k] = v
+			}
+			trace.TraceEvents = append(trace.TraceEvents, chromeTraceEvent{
+				Name:  n.effectiveName(),
+				Phase: "X",
+				TS:    start,
+				Dur:   float64(n.TotalDuration.Nanoseconds()) / 1e3,
+				PID:   1,
+				TID:   tid,
+				Args:  args,
+			})
+		}
+		childStart := start
+		for _, child := range n.snapshotChildren() {
+			if n.Async {
+				tracks++
+				walk(child, start, tracks)
+			} else {
+				walk(child, childStart, tid)
+				childStart += float64(child.TotalDuration.Nanoseconds()) / 1e3
+			}
+		}
+	}
+	walk(l.Clone(), 0, 1)
+	return json.Marshal(trace)
+}
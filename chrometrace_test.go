@@ -0,0 +1,90 @@
+package timing
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ChromeTraceJSON(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, complete := Start(context.Background(), "root")
+	dbCtx, dbComplete := Start(rootCtx, "db")
+	dbCtx.AddDetails("rows", 12)
+	dbComplete()
+	workersCtx, workersComplete := StartAsync(rootCtx, "workers")
+	for _, name := range []string{"worker 1", "worker 2"} {
+		_, workerComplete := Start(workersCtx, name)
+		workerComplete()
+	}
+	workersComplete()
+	complete()
+
+	rootCtx.TotalDuration = 100 * time.Millisecond
+	dbCtx.TotalDuration = 40 * time.Millisecond
+	workersCtx.TotalDuration = 50 * time.Millisecond
+	workersCtx.Children["worker 1"].TotalDuration = 30 * time.Millisecond
+	workersCtx.Children["worker 2"].TotalDuration = 50 * time.Millisecond
+
+	b, err := rootCtx.ChromeTraceJSON()
+	assert.NoError(t, err)
+	expected := `{"traceEvents":[` +
+		`{"name":"root","ph":"X","ts":0,"dur":100000,"pid":1,"tid":1,"args":{"entries":1}},` +
+		`{"name":"db","ph":"X","ts":0,"dur":40000,"pid":1,"tid":1,"args":{"entries":1,"rows":12}},` +
+		`{"name":"[workers]","ph":"X","ts":40000,"dur":50000,"pid":1,"tid":1,"args":{"entries":1}},` +
+		`{"name":"worker 1","ph":"X","ts":40000,"dur":30000,"pid":1,"tid":2,"args":{"entries":1}},` +
+		`{"name":"worker 2","ph":"X","ts":40000,"dur":50000,"pid":1,"tid":3,"args":{"entries":1}}]}`
+	assert.Equal(t, expected, string(b))
+}
+
+func Test_ChromeTraceJSON_Empty(t *testing.T) {
+	b, err := (&Location{}).ChromeTraceJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"traceEvents":[]}`, string(b))
+}
+
+func Test_ChromeTraceJSONWhileRunning(t *testing.T) {
+	rootCtx, complete := Start(context.Background(), "root")
+	var names []string
+	for i := 0; i < 10; i++ {
+		childCtx, childComplete := Start(rootCtx, "child "+strconv.Itoa(i))
+		for j := 0; j < 20; j++ {
+			name := "grandchild " + strconv.Itoa(j)
+			grandchildCtx, grandchildComplete := Start(childCtx, name)
+			_, leafComplete := Start(grandchildCtx, "leaf")
+			leafComplete()
+			grandchildComplete()
+		}
+		childComplete()
+		names = append(names, childCtx.Name)
+	}
+
+	// The locations, which already have children, are made Async while the trace is being built.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, name := range names {
+			childCtx, childComplete := StartAsync(rootCtx, name)
+			childComplete()
+			for j := 0; j < 20; j++ {
+				_, grandchildComplete := StartAsync(childCtx, "grandchild "+strconv.Itoa(j))
+				grandchildComplete()
+			}
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		_, err := rootCtx.ChromeTraceJSON()
+		assert.NoError(t, err)
+	}
+	complete()
+}
@@ -0,0 +1,143 @@
+package timing
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// DecayingCollector aggregates many timing trees into a single tree where older contributions
+// gradually lose weight. Each time the collector is updated, everything that was accumulated so far
+// is decayed exponentially based on the wall time since the last update, so that a contribution
+// that is one half-life old counts half as much as a new one. This keeps the aggregate
+// representative of recent behavior, which is useful for things like long-running dashboards.
+//
+// A DecayingCollector is safe for concurrent use.
+type DecayingCollector struct {
+	mu sync.Mutex
+
+	halfLife   time.Duration
+	root       *decayNode
+	lastUpdate time.Time
+
+	// now returns the current time. This is replaceable for testing.
+	now func() time.Time
+}
+
+// decayNode holds the decayed values for a single location. The values are kept as floats since
+// decaying whole numbers would quickly round everything down to zero.
+type decayNode struct {
+	name      string
+	async     bool
+	entries   float64
+	exits     float64
+	duration  float64
+	children  map[string]*decayNode
+	callOrder []string
+}
+
+// NewDecayingCollector creates a collector where contributions lose half of their weight every
+// halfLife.
+func NewDecayingCollector(halfLife time.Duration) *DecayingCollector {
+	if halfLife <= 0 {
+		panic("half-life must be positive")
+	}
+	return &DecayingCollector{
+		halfLife: halfLife,
+		root:     &decayNode{},
+		now:      time.Now,
+	}
+}
+
+// Add decays the aggregated data to the current time and then adds the timings from root to it.
+// If root is an unnamed root, its children are added to the top level of the aggregate.
+func (dc *DecayingCollector) Add(root *Location) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.decayTo(dc.now())
+	if root.Name == "" {
+		for _, c := range root.snapshotChildren() {
+			dc.root.child(c.Name).add(c)
+		}
+	} else {
+		dc.root.child(root.Name).add(root)
+	}
+}
+
+// Snapshot returns the aggregated tree, decayed to the current time. The returned tree is unnamed
+// and independent of the collector. Counts are rounded to the nearest whole number.
+func (dc *DecayingCollector) Snapshot() *Location {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.decayTo(dc.now())
+	return dc.root.toLocation()
+}
+
+// decayTo applies the decay for the time that has passed since the last update.
+func (dc *DecayingCollector) decayTo(now time.Time) {
+	if !dc.lastUpdate.IsZero() {
+		elapsed := now.Sub(dc.lastUpdate)
+		if elapsed > 0 {
+			dc.root.decay(math.Pow(0.5, float64(elapsed)/float64(dc.halfLife)))
+		}
+	}
+	dc.lastUpdate = now
+}
+
+// child gets or creates the child node with the given name.
+func (n *decayNode) child(name string) *decayNode {
+	if n.children == nil {
+		n.children = map[string]*decayNode{}
+	}
+	c, ok := n.children[name]
+	if !ok {
+		c = &decayNode{name: name}
+		n.children[name] = c
+		n.callOrder = append(n.callOrder, name)
+	}
+	return c
+}
+
+// add recursively adds the values from the location to this node.
+func (n *decayNode) add(l *Location) {
+	n.async = n.async || l.isAsync()
+	n.entries += float64(l.Entries())
+	n.exits += float64(l.Exits())
+	n.duration += float64(l.Duration())
+	for _, c := range l.snapshotChildren() {
+		n.child(c.Name).add(c)
+	}
+}
+
+// decay recursively scales all the values of this node by factor.
+func (n *decayNode) decay(factor float64) {
+	n.entries *= factor
+	n.exits *= factor
+	n.duration *= factor
+	for _, c := range n.children {
+		c.decay(factor)
+	}
+}
+
+// toLocation recursively converts this node to a Location.
+func (n *decayNode) toLocation() *Location {
+	l := &Location{
+		Name:          n.name,
+		Async:         n.async,
+		EntryCount:    uint64(math.Round(n.entries)),
+		ExitCount:     uint64(math.Round(n.exits)),
+		TotalDuration: time.Duration(math.Round(n.duration)),
+	}
+	for _, name := range n.callOrder {
+		if l.Children == nil {
+			l.Children = map[string]*Location{}
+		}
+		cl := n.children[name].toLocation()
+		cl.parent = l
+		l.Children[name] = cl
+		l.CallOrder = append(l.CallOrder, name)
+	}
+	return l
+}
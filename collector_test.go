@@ -0,0 +1,85 @@
+package timing
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_DecayingCollector(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	dc := NewDecayingCollector(time.Minute)
+	dc.now = func() time.Time {
+		return now
+	}
+
+	makeTree := func(d time.Duration) *Location {
+		rootCtx, rootComplete := Start(context.Background(), "request")
+		childCtx, childComplete := Start(rootCtx, "db")
+		childComplete()
+		rootComplete()
+		rootCtx.TotalDuration = d
+		childCtx.TotalDuration = d / 2
+		return rootCtx.Location
+	}
+
+	dc.Add(makeTree(800 * time.Millisecond))
+
+	snap := dc.Snapshot()
+	assert.Equal(t, "request - 800ms\nrequest > db - 400ms", snap.String())
+
+	// One half-life later the old contribution counts half as much as the new one.
+	now = now.Add(time.Minute)
+	dc.Add(makeTree(100 * time.Millisecond))
+
+	snap = dc.Snapshot()
+	request := snap.Children["request"]
+	assert.Equal(t, 500*time.Millisecond, request.TotalDuration)
+	assert.Equal(t, 250*time.Millisecond, request.Children["db"].TotalDuration)
+	assert.Same(t, request, request.Children["db"].Parent())
+
+	// Two more half-lives scale everything by a quarter.
+	now = now.Add(2 * time.Minute)
+	snap = dc.Snapshot()
+	assert.Equal(t, 125*time.Millisecond, snap.Children["request"].TotalDuration)
+	assert.Equal(t, uint64(0), snap.Children["request"].EntryCount)
+
+	// Unnamed roots are merged into the top level.
+	root := Root(context.Background())
+	_, complete := Start(root, "request")
+	complete()
+	dc.Add(root.Location)
+	assert.Len(t, dc.Snapshot().Children, 1)
+
+	assert.Panics(t, func() {
+		NewDecayingCollector(0)
+	})
+}
+
+func Test_DecayingCollectorWhileRunning(t *testing.T) {
+	dc := NewDecayingCollector(time.Minute)
+	rootCtx, rootComplete := StartAsync(context.Background(), "request")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, complete := StartAsync(rootCtx, "worker "+strconv.Itoa(j%5))
+				complete()
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		dc.Add(rootCtx.Location)
+	}
+	wg.Wait()
+	rootComplete()
+
+	dc.Add(rootCtx.Location)
+	assert.True(t, dc.Snapshot().Children["request"].Async)
+}
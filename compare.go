@@ -0,0 +1,123 @@
+package timing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// missingDuration is shown in place of a duration for a location that is not in one of the runs.
+const missingDuration = "—"
+
+// CompareReport generates a report showing the timings of two runs side by side. Every location that
+// appears in either run is shown once with the baseline duration, the current duration, and the
+// change as a percentage of the baseline, e.g. "root - 210ms / 250ms (+19%)". Locations that are only
+// in one of the runs show "—" for the other run and are marked as added or removed. The roots are
+// compared with each other regardless of their names.
+//
+// The options are applied to both runs in the same way as they are for Report. Details are not shown.
+// Either run may be nil, and if both are the report is empty.
+func CompareReport(baseline, current *Location, opts ReportOptions) string {
+	if baseline == nil && current == nil {
+		return ""
+	}
+	opts.applyDefaults()
+	b := strings.Builder{}
+	compareToBuilder(&b, baseline, current, "", &opts)
+	return b.String()
+}
+
+// compareToBuilder recursively writes the comparison of two matching locations, either of which may be nil.
+func compareToBuilder(b *strings.Builder, baseline, current *Location, path string, options *ReportOptions) {
+	either := current
+	if either == nil {
+		either = baseline
+	}
+
+	childPrefix := path
+	if either.Name != "" {
+		async := baseline != nil && baseline.isAsync() || current != nil && current.isAsync()
+		effectiveName := either.Name
+		if async {
+			effectiveName = "[" + either.Name + "]"
+		}
+
+		if isEntered(baseline) || isEntered(current) {
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(options.Prefix)
+			b.WriteString(path)
+			b.WriteString(effectiveName)
+			b.WriteString(" - ")
+			b.WriteString(compareDuration(baseline, options))
+			b.WriteString(" / ")
+			b.WriteString(compareDuration(current, options))
+			switch {
+			case !isEntered(baseline):
+				b.WriteString(" (added)")
+			case !isEntered(current):
+				b.WriteString(" (removed)")
+			default:
+				base := baseline.reportedDuration(options)
+				if base != 0 {
+					delta := float64(current.reportedDuration(options)-base) / float64(base) * 100
+					b.WriteString(fmt.Sprintf(" (%+.0f%%)", delta))
+				}
+			}
+		}
+
+		if options.Compact {
+			childPrefix = path + options.Separator
+		} else {
+			childPrefix = path + effectiveName + options.Separator
+		}
+	}
+
+	for _, pair := range pairChildren(baseline, current) {
+		compareToBuilder(b, pair.baseline, pair.current, childPrefix, options)
+	}
+}
+
+// isEntered returns true if the location exists and has been entered.
+func isEntered(l *Location) bool {
+	return l != nil && l.Entries() > 0
+}
+
+// compareDuration formats the reported duration of a location, or "—" if it has not been entered.
+func compareDuration(l *Location, options *ReportOptions) string {
+	if !isEntered(l) {
+		return missingDuration
+	}
+	return options.formatDuration(l.reportedDuration(options))
+}
+
+// childPair is a child of the baseline and the child with the same name in the current run, either of
+// which may be nil.
+type childPair struct {
+	baseline *Location
+	current  *Location
+}
+
+// pairChildren matches up the children of both locations, either of which may be nil, by name. The
+// baseline's children come first in their call order, followed by any children that are only in the
+// current run.
+func pairChildren(baseline, current *Location) []childPair {
+	var pairs []childPair
+	index := map[string]int{}
+	if baseline != nil {
+		for _, c := range baseline.snapshotChildren() {
+			index[c.Name] = len(pairs)
+			pairs = append(pairs, childPair{baseline: c})
+		}
+	}
+	if current != nil {
+		for _, c := range current.snapshotChildren() {
+			if i, ok := index[c.Name]; ok {
+				pairs[i].current = c
+			} else {
+				pairs = append(pairs, childPair{current: c})
+			}
+		}
+	}
+	return pairs
+}
@@ -2,6 +2,8 @@ package timing
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 )
 
@@ -15,11 +17,38 @@ type contextTimingType int
 
 const ContextTimingKey contextTimingType = 0
 
+// onCompleteKey is the context key that holds the OnCompleteFunc set by WithOnComplete.
+const onCompleteKey contextTimingType = 1
+
+// OnCompleteFunc is called by WithOnComplete each time a timing is completed, with the full path of
+// the location, the duration of the call that was just completed, and the location itself.
+type OnCompleteFunc func(path string, d time.Duration, l *Location)
+
 // Start begins a timing context and relates it to a preceding timing context if it exists.
 // If a previous context does not exist then this starts a new named root timing context.
 func Start(ctx context.Context, name string) (*Context, Complete) {
 	c := ForName(ctx, name)
-	return c, c.Start()
+	return c, startNotifying(ctx, c.Location)
+}
+
+// Startf is like Start, but the name is formatted with fmt.Sprintf. Each distinct name creates a
+// separate child location that is kept for the life of the timing tree, so the name should only be
+// built from values with few possible values, such as a table name, and never from things like user
+// or request IDs. Use AddDetails for those instead.
+func Startf(ctx context.Context, format string, args ...interface{}) (*Context, Complete) {
+	return Start(ctx, fmt.Sprintf(format, args...))
+}
+
+// StartWithDetails is like Start, but the details are added to the timing context before it is
+// started. The details are merged into any that the location already has from prior calls: a key
+// that it already has is replaced with the new value, and all the others are kept. New keys are added
+// in order by their names, which matters for ReportOptions.DetailsInOrder.
+func StartWithDetails(ctx context.Context, name string, details map[string]interface{}) (*Context, Complete) {
+	c := ForName(ctx, name)
+	if !c.isDisabled() && len(details) > 0 {
+		c.addDetailMap(details)
+	}
+	return c, startNotifying(ctx, c.Location)
 }
 
 // StartAsync begins a timing context and relates it to a preceding timing context if it exists.
@@ -27,10 +56,16 @@ func Start(ctx context.Context, name string) (*Context, Complete) {
 // This is similar to Start except that it will mark the context as Async, which means that
 // the child contexts will not be excluded from the parent's time. This is useful for timing
 // contexts that overlap.
+//
+// Any number of Goroutines may start children of an Async context at once. Those that use the same
+// name share a single child location that is only added to the call order once, and its counts,
+// durations, and fastest and slowest calls include every call.
 func StartAsync(ctx context.Context, name string) (*Context, Complete) {
 	c := ForName(ctx, name)
-	c.Async = true
-	return c, c.Start()
+	if !c.isDisabled() {
+		c.markAsync()
+	}
+	return c, startNotifying(ctx, c.Location)
 }
 
 // Root creates a new unnamed timing context. This is similar to Start except there are no timers
@@ -46,6 +81,29 @@ func Root(ctx context.Context) *Context {
 	return c
 }
 
+// ContextWithTiming makes sure that there is a timing context on the context stack without ever
+// shadowing an existing one. If there is no timing context yet, a new unnamed root is created, just
+// like with Root. If there already is one, calling Root would start a new unrelated tree, and
+// anything timed under it would be missing from the existing tree's report. Instead, if subRoot is
+// empty the existing timing context is reused, otherwise an un-started child with the subRoot name
+// is created under the existing timing context to group everything that is timed under it.
+func ContextWithTiming(ctx context.Context, subRoot string) *Context {
+	if ctx == nil {
+		panic("context must be defined")
+	}
+	p := findParentTiming(ctx)
+	if p == nil {
+		return Root(ctx)
+	}
+	if subRoot == "" {
+		return &Context{
+			prevCtx:  ctx,
+			Location: p.Location,
+		}
+	}
+	return p.getChild(ctx, subRoot)
+}
+
 // StartRoot creates a new named timing context. Unlike Start, this will create a new unrelated timing
 // context regardless if there is a timing context already on the context stack. This is useful
 // for any long-running processes that finish after the Goroutine that started them have finished.
@@ -53,13 +111,115 @@ func StartRoot(ctx context.Context, name string) (*Context, Complete) {
 	if ctx == nil {
 		panic("context must be defined")
 	}
-	c := &Context{
-		prevCtx: ctx,
-		Location: &Location{
-			Name: name,
-		},
+	if !Enabled() {
+		return disabledContext(ctx), noopComplete
 	}
-	return c, c.Start()
+	c := newContext(ctx, newLocation(name))
+	return c, startNotifying(ctx, c.Location)
+}
+
+// Transaction times an entire unit of work, such as the handling of a request. A new named root
+// timing context is started, regardless of any timing context already on the context stack, and
+// fn is called with it. The timing is completed when fn returns, and the completed root Location
+// is returned along with the error from fn so that the caller can report or export it.
+//
+// If fn panics, the timing is still completed, the panic is recorded as a "panic" detail on the
+// root, and the panic is returned as an error so that the timings for the failed work are not lost.
+func Transaction(ctx context.Context, name string, fn func(ctx context.Context) error) (loc *Location, err error) {
+	c, complete := StartRoot(ctx, name)
+	loc = c.Location
+	defer func() {
+		complete()
+		if r := recover(); r != nil {
+			c.AddDetails("panic", r)
+			err = fmt.Errorf("panic in transaction %s: %v", name, r)
+		}
+	}()
+	err = fn(c)
+	return
+}
+
+// Time times a call to fn as a child timing context named name. The timing is completed even if fn
+// panics, in which case the panic continues once the timing is completed. The timing context is
+// returned so that details can be added to it afterward.
+func Time(ctx context.Context, name string, fn func()) *Context {
+	c, complete := Start(ctx, name)
+	defer complete()
+	fn()
+	return c
+}
+
+// TimeErr times a call to fn as a child timing context named name, and returns the error from fn
+// unchanged. If fn returns an error, its message is recorded as the "error" detail so that the
+// failed calls stand out in the report. Like Time, the timing is completed even if fn panics.
+func TimeErr(ctx context.Context, name string, fn func() error) error {
+	c, complete := Start(ctx, name)
+	defer complete()
+	err := fn()
+	if err != nil {
+		c.AddDetails("error", err.Error())
+	}
+	return err
+}
+
+// TimeWait times how long it takes for wg.Wait() to return as a child timing context named name.
+// This makes the time spent at the fan-in barrier of a fan-out/fan-in pattern visible in the timing
+// tree next to the Async children that do the actual work.
+func TimeWait(ctx context.Context, name string, wg *sync.WaitGroup) {
+	_, complete := Start(ctx, name)
+	defer complete()
+	wg.Wait()
+}
+
+// WithOnComplete returns a context that causes fn to be called every time a timing that is started
+// under it with Start, StartAsync, or StartRoot is completed. This allows each timing to be pushed to
+// another metrics system as it happens, instead of walking the timing tree afterward. The fn is called
+// on the Goroutine that completes the timing, so it must be safe for concurrent use, and it should be
+// quick since it delays whatever is completing the timing.
+func WithOnComplete(ctx context.Context, fn OnCompleteFunc) context.Context {
+	return context.WithValue(ctx, onCompleteKey, fn)
+}
+
+// startNotifying starts the location and, if a callback was set with WithOnComplete or a budget was
+// set with WithBudget, arranges for them to be updated when the timing is completed. Under
+// WithCancellationTracking, the timing is also checked for having been cut short.
+func startNotifying(ctx context.Context, l *Location) Complete {
+	if l.isDisabled() {
+		return noopComplete
+	}
+	fn, _ := ctx.Value(onCompleteKey).(OnCompleteFunc)
+	b := budgetFor(ctx, l)
+	track := tracksCancellation(ctx)
+	if fn == nil && b == nil && !track {
+		return l.Start()
+	}
+	return l.start(func(d time.Duration) {
+		if track {
+			recordCancellation(ctx, l)
+		}
+		if b != nil {
+			b.charge(d)
+		}
+		if fn != nil {
+			fn(l.CachedPath(), d, l)
+		}
+	})
+}
+
+// Record adds a call that took d to the child timing context named name, creating it if needed.
+// This is for durations that were measured elsewhere, such as a query time that is reported by a
+// database driver. The call is recorded just like one that was timed with Start and completed d later.
+func (c *Context) Record(name string, d time.Duration) {
+	if name == "" {
+		panic("non-root timings must be named")
+	}
+	if !Enabled() || c.isDisabled() {
+		return
+	}
+	child := c.getChild(c, name)
+	end := now()
+	child.recordEntry(end.Add(-d), 1)
+	child.recordExit(end, d, 1)
 }
 
 // ForName returns an un-started Context. This is generally not used by client code, but
@@ -72,15 +232,12 @@ func ForName(ctx context.Context, name string) *Context {
 	if ctx == nil {
 		panic("context must be defined")
 	}
+	if !Enabled() {
+		return disabledContext(ctx)
+	}
 	p := findParentTiming(ctx)
 	if p == nil {
-		c := &Context{
-			prevCtx: ctx,
-			Location: &Location{
-				Name: name,
-			},
-		}
-		return c
+		return newContext(ctx, newLocation(name))
 	} else {
 		return p.getChild(ctx, name)
 	}
@@ -0,0 +1,85 @@
+package timing
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportCSV generates the timings as CSV, for importing into a spreadsheet. See WriteCSV for the
+// columns.
+func (l *Location) ReportCSV(options ReportOptions) string {
+	b := strings.Builder{}
+	_ = l.WriteCSV(&b, options)
+	return b.String()
+}
+
+// WriteCSV writes the timings as CSV to w. After a header row, there is a row for each location that
+// has been entered with the columns:
+//
+//   - path: the names of the location and its ancestors, joined with the Separator.
+//   - duration: the reported duration, formatted with the DurationFormatter.
+//   - entries and exits: the number of times the location was entered and exited.
+//   - per_call: the reported duration divided by the number of exits, or empty if it hasn't exited.
+//   - details: the details of the location as a JSON object, or empty if there are none.
+//
+// ExcludeChildren, Separator, DurationFormatter, SubtractOverhead, ChildLess, and SortBy are honored.
+// The other options don't apply. The first error that happens while writing is returned.
+func (l *Location) WriteCSV(w io.Writer, options ReportOptions) error {
+	options.applyDefaults()
+	options.root = l
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"path", "duration", "entries", "exits", "per_call", "details"}); err != nil {
+		return err
+	}
+	if err := l.writeCSVRows(cw, "", &options); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeCSVRows recursively writes the CSV rows of a location and its descendants.
+func (l *Location) writeCSVRows(cw *csv.Writer, path string, options *ReportOptions) error {
+	childPrefix := path
+	if l.Name != "" {
+		path += l.effectiveName()
+		childPrefix = path + options.Separator
+		if l.Entries() > 0 {
+			n := l.copyNode()
+			reportDuration := l.reportedDuration(options)
+			perCall := ""
+			if n.ExitCount > 0 {
+				perCall = options.formatDuration(time.Duration(float64(reportDuration) / float64(n.ExitCount)))
+			}
+			details := ""
+			if len(n.Details) > 0 {
+				b, err := json.Marshal(n.Details)
+				if err != nil {
+					return err
+				}
+				details = string(b)
+			}
+			err := cw.Write([]string{
+				path,
+				options.formatDuration(reportDuration),
+				strconv.FormatUint(n.EntryCount, 10),
+				strconv.FormatUint(n.ExitCount, 10),
+				perCall,
+				details,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, c := range l.orderedChildren(options) {
+		if err := c.writeCSVRows(cw, childPrefix, options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package timing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ReportCSV(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, complete := Start(context.Background(), "root")
+	childCtx, childComplete := Start(rootCtx, "child, 1")
+	childComplete()
+	_, childComplete = Start(rootCtx, "child, 1")
+	childComplete()
+	childCtx.AddDetails("rows", 12)
+	childCtx.AddDetails("table", "users")
+	asyncCtx, asyncComplete := StartAsync(rootCtx, "async")
+	asyncComplete()
+	complete()
+
+	rootCtx.TotalDuration = 100 * time.Millisecond
+	childCtx.TotalDuration = 60 * time.Millisecond
+	asyncCtx.TotalDuration = 30 * time.Millisecond
+
+	expected := `path,duration,entries,exits,per_call,details
+root,100ms,1,1,100ms,
+"root > child, 1",60ms,2,2,30ms,"{""rows"":12,""table"":""users""}"
+root > [async],30ms,1,1,30ms,
+`
+	assert.Equal(t, expected, rootCtx.ReportCSV(ReportOptions{}))
+
+	expected = `path,duration,entries,exits,per_call,details
+root,10ms,1,1,10ms,
+"root/child, 1",60ms,2,2,30ms,"{""rows"":12,""table"":""users""}"
+root/[async],30ms,1,1,30ms,
+`
+	assert.Equal(t, expected, rootCtx.ReportCSV(ReportOptions{Separator: "/", ExcludeChildren: true}))
+}
+
+func Test_WriteCSV_Error(t *testing.T) {
+	rootCtx, complete := Start(context.Background(), "root")
+	complete()
+
+	err := rootCtx.WriteCSV(&failingWriter{limit: 5}, ReportOptions{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,345 @@
+package timing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"time"
+)
+
+// deltaVersion is the version of the delta format. It is the first byte of every delta.
+const deltaVersion = 2
+
+// Flags that mark which fields of a location are included in a delta.
+const (
+	deltaEntryCount = 1 << iota
+	deltaExitCount
+	deltaDuration
+	deltaAsync
+	deltaDetails
+	deltaNote
+	deltaMinDuration
+	deltaMaxDuration
+)
+
+// ErrInvalidDelta is returned by DeltaApply if the delta is corrupt or doesn't match the baseline.
+var ErrInvalidDelta = errors.New("invalid timing delta")
+
+// DeltaEncode computes a compact binary delta that turns the baseline tree into the current tree.
+// Only the fields that have changed are stored, numbers are stored as variable-length differences,
+// and children that exist in the baseline are referred to by their position rather than their name,
+// so the delta of two similar trees is much smaller than either tree. This allows a history of runs
+// to be stored as a single baseline and a delta per run.
+//
+// The delta covers the names, including that of the root, counts, durations, fastest and slowest
+// calls, Async flags, details, and notes of the locations. Details are stored as JSON, so their
+// values come back as the types JSON decodes to. Anything else, such as the sections, histograms,
+// cancellation counts, and the times of the first entry and last exit, is left out, so the tree that
+// DeltaApply returns doesn't have any of it. Both trees are read from a Clone of them, so this is
+// safe to call while they are still being timed.
+func DeltaEncode(baseline, current *Location) ([]byte, error) {
+	if baseline == nil || current == nil {
+		return nil, errors.New("baseline and current must both be specified")
+	}
+	baseline = baseline.Clone()
+	current = current.Clone()
+
+	buf := bytes.Buffer{}
+	buf.WriteByte(deltaVersion)
+	writeRootName(&buf, baseline, current)
+	if err := encodeDelta(&buf, baseline, current); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DeltaApply applies a delta made by DeltaEncode to the baseline it was made from, returning a new
+// tree that matches the current tree the delta was made with. The baseline is not modified, and is
+// read from a Clone of it.
+func DeltaApply(baseline *Location, delta []byte) (*Location, error) {
+	if baseline == nil {
+		return nil, errors.New("baseline must be specified")
+	}
+	r := bytes.NewReader(delta)
+	version, err := r.ReadByte()
+	if err != nil || version != deltaVersion {
+		return nil, ErrInvalidDelta
+	}
+	name, renamed, err := readRootName(r)
+	if err != nil {
+		return nil, err
+	}
+	result, err := applyDelta(r, baseline.Clone())
+	if err != nil {
+		return nil, err
+	}
+	if renamed {
+		result.Name = name
+	}
+	if r.Len() != 0 {
+		return nil, ErrInvalidDelta
+	}
+	return result, nil
+}
+
+// encodeDelta recursively writes the delta from the baseline location, which may be nil, to the
+// current location.
+func encodeDelta(buf *bytes.Buffer, baseline, current *Location) error {
+	if baseline == nil {
+		baseline = &Location{}
+	}
+
+	var flags byte
+	if current.Entries() != baseline.Entries() {
+		flags |= deltaEntryCount
+	}
+	if current.Exits() != baseline.Exits() {
+		flags |= deltaExitCount
+	}
+	if current.Duration() != baseline.Duration() {
+		flags |= deltaDuration
+	}
+	if current.isAsync() != baseline.isAsync() {
+		flags |= deltaAsync
+	}
+	if current.MinDuration != baseline.MinDuration {
+		flags |= deltaMinDuration
+	}
+	if current.MaxDuration != baseline.MaxDuration {
+		flags |= deltaMaxDuration
+	}
+	if !reflect.DeepEqual(current.Details, baseline.Details) {
+		flags |= deltaDetails
+	}
+	if current.NoteText != baseline.NoteText {
+		flags |= deltaNote
+	}
+	buf.WriteByte(flags)
+
+	if flags&deltaEntryCount != 0 {
+		writeVarint(buf, int64(current.Entries())-int64(baseline.Entries()))
+	}
+	if flags&deltaExitCount != 0 {
+		writeVarint(buf, int64(current.Exits())-int64(baseline.Exits()))
+	}
+	if flags&deltaDuration != 0 {
+		writeVarint(buf, int64(current.Duration()-baseline.Duration()))
+	}
+	if flags&deltaMinDuration != 0 {
+		writeVarint(buf, int64(current.MinDuration-baseline.MinDuration))
+	}
+	if flags&deltaMaxDuration != 0 {
+		writeVarint(buf, int64(current.MaxDuration-baseline.MaxDuration))
+	}
+	if flags&deltaDetails != 0 {
+		data, err := json.Marshal(current.Details)
+		if err != nil {
+			return err
+		}
+		writeBytes(buf, data)
+	}
+	if flags&deltaNote != 0 {
+		writeBytes(buf, []byte(current.NoteText))
+	}
+
+	baselineIndex := make(map[string]int, len(baseline.CallOrder))
+	for i, name := range baseline.CallOrder {
+		baselineIndex[name] = i
+	}
+	writeUvarint(buf, uint64(len(current.CallOrder)))
+	for _, name := range current.CallOrder {
+		if i, ok := baselineIndex[name]; ok {
+			writeUvarint(buf, uint64(i)+1)
+		} else {
+			writeUvarint(buf, 0)
+			writeBytes(buf, []byte(name))
+		}
+		if err := encodeDelta(buf, baseline.Children[name], current.Children[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyDelta recursively reads the delta for a location and applies it to the baseline location,
+// which may be an empty location for ones that are not in the baseline.
+func applyDelta(r *bytes.Reader, baseline *Location) (*Location, error) {
+	flags, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrInvalidDelta
+	}
+
+	result := baseline.copyNode()
+	result.parent = nil
+	result.clearUncovered()
+	if flags&deltaEntryCount != 0 {
+		d, err := readVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		result.EntryCount = uint64(int64(result.EntryCount) + d)
+	}
+	if flags&deltaExitCount != 0 {
+		d, err := readVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		result.ExitCount = uint64(int64(result.ExitCount) + d)
+	}
+	if flags&deltaDuration != 0 {
+		d, err := readVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		result.TotalDuration += time.Duration(d)
+	}
+	if flags&deltaAsync != 0 {
+		result.Async = !result.Async
+	}
+	if flags&deltaMinDuration != 0 {
+		d, err := readVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		result.MinDuration += time.Duration(d)
+	}
+	if flags&deltaMaxDuration != 0 {
+		d, err := readVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		result.MaxDuration += time.Duration(d)
+	}
+	if flags&deltaDetails != 0 {
+		data, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		result.Details = nil
+		result.detailOrder = nil
+		if err := json.Unmarshal(data, &result.Details); err != nil {
+			return nil, ErrInvalidDelta
+		}
+	}
+	if flags&deltaNote != 0 {
+		data, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		result.NoteText = string(data)
+	}
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, ErrInvalidDelta
+	}
+	for i := uint64(0); i < count; i++ {
+		ref, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, ErrInvalidDelta
+		}
+		var childBaseline *Location
+		if ref == 0 {
+			name, err := readBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			childBaseline = &Location{Name: string(name)}
+		} else {
+			if ref > uint64(len(baseline.CallOrder)) {
+				return nil, ErrInvalidDelta
+			}
+			childBaseline = baseline.Children[baseline.CallOrder[ref-1]]
+		}
+		child, err := applyDelta(r, childBaseline)
+		if err != nil {
+			return nil, err
+		}
+		result.addChild(child)
+	}
+	return result, nil
+}
+
+// writeRootName writes the name of the current root if it differs from that of the baseline. The
+// names of the other locations are written along with their position among their siblings.
+func writeRootName(buf *bytes.Buffer, baseline, current *Location) {
+	if current.Name == baseline.Name {
+		writeUvarint(buf, 0)
+		return
+	}
+	writeUvarint(buf, uint64(len(current.Name))+1)
+	buf.WriteString(current.Name)
+}
+
+// readRootName reads the name written by writeRootName, returning whether the root was renamed.
+func readRootName(r *bytes.Reader) (string, bool, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len())+1 {
+		return "", false, ErrInvalidDelta
+	}
+	if n == 0 {
+		return "", false, nil
+	}
+	name := make([]byte, n-1)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return "", false, ErrInvalidDelta
+	}
+	return string(name), true, nil
+}
+
+// clearUncovered clears the fields that the delta doesn't cover, so that the ones copied from the
+// baseline don't end up in the result.
+func (l *Location) clearUncovered() {
+	l.Sections = nil
+	l.sectionOrder = nil
+	l.Histogram = nil
+	l.histogramEnabled = 0
+	l.firstEntry = 0
+	l.lastEntry = 0
+	l.lastExit = 0
+	l.cancelled = 0
+	l.deadlineExceeded = 0
+}
+
+// writeVarint writes a signed variable-length integer.
+func writeVarint(buf *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+// writeUvarint writes an unsigned variable-length integer.
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+// writeBytes writes a length-prefixed byte slice.
+func writeBytes(buf *bytes.Buffer, data []byte) {
+	writeUvarint(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+// readVarint reads a signed variable-length integer.
+func readVarint(r *bytes.Reader) (int64, error) {
+	v, err := binary.ReadVarint(r)
+	if err != nil {
+		return 0, ErrInvalidDelta
+	}
+	return v, nil
+}
+
+// readBytes reads a length-prefixed byte slice.
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return nil, ErrInvalidDelta
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, ErrInvalidDelta
+	}
+	return data, nil
+}
@@ -0,0 +1,192 @@
+package timing
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_DeltaRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	baseline, complete := Start(ctx, "root")
+	for _, name := range []string{"fetch", "removed", "render"} {
+		childCtx, childComplete := Start(baseline, name)
+		childComplete()
+		childCtx.TotalDuration = 50 * time.Millisecond
+	}
+	complete()
+	baseline.TotalDuration = 200 * time.Millisecond
+	baseline.Children["fetch"].AddDetails("rows", 10)
+
+	current, complete := Start(ctx, "root")
+	for _, name := range []string{"render", "fetch", "added"} {
+		childCtx, childComplete := Start(current, name)
+		childComplete()
+		childCtx.TotalDuration = 50 * time.Millisecond
+	}
+	_, childComplete := Start(current, "fetch")
+	childComplete()
+	grandchild, grandchildComplete := StartAsync(current.Children["added"].getChild(ctx, "x"), "nested")
+	grandchildComplete()
+	grandchild.Note("slow")
+	complete()
+	current.TotalDuration = 150 * time.Millisecond
+	current.Children["fetch"].TotalDuration = 80 * time.Millisecond
+	current.Children["fetch"].AddDetails("rows", 12)
+	grandchild.TotalDuration = 20 * time.Millisecond
+
+	delta, err := DeltaEncode(baseline.Location, current.Location)
+	assert.NoError(t, err)
+
+	fullJSON, _ := json.Marshal(current)
+	assert.Less(t, len(delta), len(fullJSON)/2)
+
+	result, err := DeltaApply(baseline.Location, delta)
+	assert.NoError(t, err)
+	assert.Equal(t, current.String(), result.String())
+	assert.Equal(t, current.CallOrder, result.CallOrder)
+	assert.Equal(t, 12.0, result.Children["fetch"].Details["rows"])
+	assert.Same(t, result, result.Children["fetch"].Parent())
+
+	// The baseline is not modified
+	assert.Equal(t, 10, baseline.Children["fetch"].Details["rows"])
+	assert.Len(t, baseline.Children, 3)
+
+	// An identical tree has a tiny delta
+	delta, err = DeltaEncode(current.Location, current.Location)
+	assert.NoError(t, err)
+	assert.Less(t, len(delta), 20)
+	result, err = DeltaApply(current.Location, delta)
+	assert.NoError(t, err)
+	assert.Equal(t, current.String(), result.String())
+}
+
+func Test_DeltaRoundTripSections(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+	ctx := context.Background()
+
+	baseline, complete := Start(ctx, "root")
+	baseline.EnableHistogram([]time.Duration{time.Second})
+	lockComplete := baseline.Section("lock")
+	clock = clock.Add(10 * time.Millisecond)
+	lockComplete()
+	complete()
+
+	current, complete := Start(ctx, "root")
+	clock = clock.Add(20 * time.Millisecond)
+	complete()
+
+	delta, err := DeltaEncode(baseline.Location, current.Location)
+	assert.NoError(t, err)
+	result, err := DeltaApply(baseline.Location, delta)
+	assert.NoError(t, err)
+
+	// Nothing that the delta doesn't cover is carried over from the baseline.
+	assert.Equal(t, current.String(), result.String())
+	assert.Equal(t, "root - 20ms", result.String())
+	assert.Nil(t, result.Sections)
+	assert.Nil(t, result.Histogram)
+	assert.True(t, result.FirstEntry().IsZero())
+	assert.True(t, result.LastExit().IsZero())
+	assert.NotNil(t, baseline.Sections)
+	assert.NotNil(t, baseline.Histogram)
+}
+
+func Test_DeltaRenamedRoot(t *testing.T) {
+	ctx := context.Background()
+
+	run1, complete := Start(ctx, "run1")
+	_, childComplete := Start(run1, "child")
+	childComplete()
+	complete()
+	run2, complete := Start(ctx, "run2")
+	_, childComplete = Start(run2, "child")
+	childComplete()
+	complete()
+
+	delta, err := DeltaEncode(run1.Location, run2.Location)
+	assert.NoError(t, err)
+	result, err := DeltaApply(run1.Location, delta)
+	assert.NoError(t, err)
+	assert.Equal(t, "run2", result.Name)
+	assert.Equal(t, run2.String(), result.String())
+	assert.Equal(t, "run1", run1.Name)
+
+	// An unnamed root stays unnamed.
+	delta, err = DeltaEncode(run1.Location, Root(ctx).Location)
+	assert.NoError(t, err)
+	result, err = DeltaApply(run1.Location, delta)
+	assert.NoError(t, err)
+	assert.Equal(t, "", result.Name)
+}
+
+func Test_DeltaWhileRunning(t *testing.T) {
+	ctx := context.Background()
+	baseline, complete := Start(ctx, "root")
+	_, childComplete := Start(baseline, "worker 0")
+	childComplete()
+	complete()
+	current, complete := StartAsync(ctx, "root")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				workerCtx, workerComplete := StartAsync(current, "worker "+strconv.Itoa(j%5))
+				workerCtx.AddDetails("iteration", j)
+				workerCtx.Note("iteration " + strconv.Itoa(j))
+				workerComplete()
+				_, baselineComplete := Start(baseline, "worker "+strconv.Itoa(j%5))
+				baselineComplete()
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		delta, err := DeltaEncode(baseline.Location, current.Location)
+		assert.NoError(t, err)
+		_, err = DeltaApply(baseline.Location, delta)
+		if err != nil {
+			// The baseline may have gained children since the delta was made.
+			assert.Equal(t, ErrInvalidDelta, err)
+		}
+	}
+	wg.Wait()
+	complete()
+}
+
+func Test_DeltaErrors(t *testing.T) {
+	ctx := context.Background()
+	baseline, complete := Start(ctx, "root")
+	complete()
+	current, complete := Start(ctx, "root")
+	_, childComplete := Start(current, "child")
+	childComplete()
+	complete()
+
+	_, err := DeltaEncode(nil, current.Location)
+	assert.Error(t, err)
+	_, err = DeltaApply(nil, nil)
+	assert.Error(t, err)
+
+	delta, err := DeltaEncode(baseline.Location, current.Location)
+	assert.NoError(t, err)
+
+	_, err = DeltaApply(baseline.Location, nil)
+	assert.Equal(t, ErrInvalidDelta, err)
+	_, err = DeltaApply(baseline.Location, delta[:len(delta)-1])
+	assert.Equal(t, ErrInvalidDelta, err)
+	_, err = DeltaApply(baseline.Location, append(delta, 0))
+	assert.Equal(t, ErrInvalidDelta, err)
+
+	current.AddDetails("bad", func() {})
+	_, err = DeltaEncode(baseline.Location, current.Location)
+	assert.Error(t, err)
+}
@@ -0,0 +1,82 @@
+package timing
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_SetEnabled(t *testing.T) {
+	rootCtx, complete := Start(context.Background(), "root")
+
+	SetEnabled(false)
+	assert.False(t, Enabled())
+
+	childCtx, childComplete := Start(rootCtx, "child")
+	childCtx.AddDetails("rows", 5)
+	childCtx.Note("ignored")
+	sectionComplete := childCtx.Section("lock")
+	sectionComplete()
+	asyncCtx, asyncComplete := StartAsync(childCtx, "async")
+	asyncComplete()
+	childComplete()
+	childCtx.Record("external", time.Second)
+	otherCtx, otherComplete := StartRoot(context.Background(), "other")
+	otherComplete()
+	childCtx.Rename("renamed")
+	childCtx.Merge(rootCtx.Location)
+	childCtx.Reset()
+	childCtx.EnableHistogram([]time.Duration{time.Second})
+	childCtx.RecordSamples(10)
+
+	assert.Same(t, childCtx, asyncCtx)
+	assert.Same(t, disabledLocation, otherCtx.Location)
+	assert.Equal(t, uint64(0), disabledLocation.EntryCount)
+	assert.Nil(t, disabledLocation.Details)
+	assert.Nil(t, disabledLocation.Children)
+	assert.False(t, disabledLocation.Async)
+	assert.Equal(t, "", disabledLocation.NoteText)
+	assert.Equal(t, "disabled", disabledLocation.Name)
+	assert.Nil(t, disabledLocation.Histogram)
+	assert.Nil(t, disabledLocation.samples)
+
+	// The disabled context still passes through the values of the context it wraps.
+	type key struct{}
+	valueCtx, valueComplete := Start(context.WithValue(context.Background(), key{}, "v"), "x")
+	valueComplete()
+	assert.Equal(t, "v", valueCtx.Value(key{}))
+
+	SetEnabled(true)
+	complete()
+
+	assert.Nil(t, rootCtx.Children)
+	assert.Equal(t, uint64(1), rootCtx.ExitCount)
+}
+
+func Benchmark_StartDisabled(b *testing.B) {
+	SetEnabled(false)
+	defer SetEnabled(true)
+
+	ctx, complete := Start(context.Background(), "root")
+	defer complete()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, complete := Start(ctx, "query")
+		c.AddDetails("rows", 1)
+		complete()
+	}
+}
+
+func Benchmark_StartEnabled(b *testing.B) {
+	ctx, complete := Start(context.Background(), "root")
+	defer complete()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, complete := Start(ctx, "query")
+		c.AddDetails("rows", 1)
+		complete()
+	}
+}
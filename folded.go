@@ -0,0 +1,44 @@
+package timing
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FoldedStacks generates the timing tree in the collapsed stack format that Brendan Gregg's
+// flamegraph.pl and similar tools consume. There is a line for every location with the names of it
+// and its ancestors separated by semicolons, followed by its self-time divided by divisor and rounded
+// to a whole number, such as:
+//
+//	root;child 1;leaf 123
+//
+// The self-time of a location is its duration less that of its children, except for Async locations,
+// whose full duration is used as it is for ReportMap with excludeChildren. Locations that have not
+// been entered, or whose scaled self-time rounds to zero, are left out. Semicolons in the names of the
+// locations are replaced with underscores so that they don't split the stack.
+func (l *Location) FoldedStacks(divisor float64) string {
+	b := strings.Builder{}
+	var walk func(n *Location, stack string)
+	walk = func(n *Location, stack string) {
+		if n.Name != "" {
+			if stack != "" {
+				stack += ";"
+			}
+			stack += strings.ReplaceAll(n.Name, ";", "_")
+			self := n.ReportedDuration(true)
+			weight := int64(math.Round(float64(self.Nanoseconds()) / divisor))
+			if n.Entries() > 0 && weight > 0 {
+				b.WriteString(stack)
+				b.WriteString(" ")
+				b.WriteString(strconv.FormatInt(weight, 10))
+				b.WriteString("\n")
+			}
+		}
+		for _, c := range n.snapshotChildren() {
+			walk(c, stack)
+		}
+	}
+	walk(l, "")
+	return b.String()
+}
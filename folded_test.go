@@ -0,0 +1,41 @@
+package timing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FoldedStacks(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, complete := Start(context.Background(), "root")
+	childCtx, childComplete := Start(rootCtx, "child;1")
+	leafCtx, leafComplete := Start(childCtx, "leaf")
+	leafComplete()
+	childComplete()
+	asyncCtx, asyncComplete := StartAsync(rootCtx, "async")
+	workerCtx, workerComplete := Start(asyncCtx, "worker")
+	workerComplete()
+	asyncComplete()
+	_, idleComplete := Start(rootCtx, "idle")
+	idleComplete()
+	complete()
+
+	rootCtx.TotalDuration = 100 * time.Millisecond
+	childCtx.TotalDuration = 60 * time.Millisecond
+	leafCtx.TotalDuration = 45 * time.Millisecond
+	asyncCtx.TotalDuration = 30 * time.Millisecond
+	workerCtx.TotalDuration = 25 * time.Millisecond
+
+	expected := `root 10
+root;child_1 15
+root;child_1;leaf 45
+root;async 30
+root;async;worker 25
+`
+	assert.Equal(t, expected, rootCtx.FoldedStacks(float64(time.Millisecond)))
+}
@@ -0,0 +1,139 @@
+package timing
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram counts the calls of a location by their duration. See EnableHistogram.
+type Histogram struct {
+	// Buckets are the upper bounds of the buckets, in increasing order. A call is counted in the first
+	// bucket whose bound it is less than or equal to.
+	Buckets []time.Duration `json:"buckets"`
+
+	// Counts has the number of calls in each bucket. It has one more entry than Buckets, for the calls
+	// that are slower than the last bound.
+	Counts []uint64 `json:"counts"`
+}
+
+// EnableHistogram turns on counting the calls of this location into buckets by their duration, with
+// the buckets being the upper bounds of each bucket. This is what makes it possible to estimate the
+// percentiles of the call durations with Quantile, rather than just the average. Only the calls that
+// complete after this are counted, and calling this again starts over with the new buckets. Passing no
+// buckets turns the histogram off and discards it.
+//
+// The counts are included in the JSON, and the reports show the estimated p50, p90, and p99 of the
+// locations that have a histogram.
+func (l *Location) EnableHistogram(buckets []time.Duration) {
+	if l.isDisabled() {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(buckets) == 0 {
+		l.Histogram = nil
+		atomic.StoreInt32(&l.histogramEnabled, 0)
+		return
+	}
+	bounds := append([]time.Duration(nil), buckets...)
+	sort.Slice(bounds, func(i, j int) bool {
+		return bounds[i] < bounds[j]
+	})
+	l.Histogram = &Histogram{
+		Buckets: bounds,
+		Counts:  make([]uint64, len(bounds)+1),
+	}
+	atomic.StoreInt32(&l.histogramEnabled, 1)
+}
+
+// HistogramCounts returns a copy of the histogram of this location, or nil if EnableHistogram hasn't
+// been called. This is safe to call while timings are being completed.
+func (l *Location) HistogramCounts() *Histogram {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.Histogram.clone()
+}
+
+// observeHistogram counts a call of the duration in the histogram. A sampled call counts as the
+// number of calls that it stands for.
+func (l *Location) observeHistogram(d time.Duration, weight uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.Histogram == nil {
+		return
+	}
+	i := sort.Search(len(l.Histogram.Buckets), func(i int) bool {
+		return d <= l.Histogram.Buckets[i]
+	})
+	l.Histogram.Counts[i] += weight
+}
+
+// clone returns a copy of the histogram. This is nil if the histogram is.
+func (h *Histogram) clone() *Histogram {
+	if h == nil {
+		return nil
+	}
+	return &Histogram{
+		Buckets: append([]time.Duration(nil), h.Buckets...),
+		Counts:  append([]uint64(nil), h.Counts...),
+	}
+}
+
+// Total returns the number of calls that have been counted.
+func (h *Histogram) Total() uint64 {
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	return total
+}
+
+// Quantile estimates the duration that the fraction q of the calls were at least as fast as, e.g. 0.99
+// for the p99. The estimate is interpolated linearly within the bucket that the quantile falls into,
+// with the first bucket starting at zero. If it falls past the last bound, the last bound is returned,
+// so the estimate is only as good as the buckets allow. This returns zero if no calls have been
+// counted.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	total := h.Total()
+	if total == 0 || len(h.Buckets) == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var cumulative uint64
+	for i, count := range h.Counts {
+		if count == 0 || float64(cumulative+count) < rank {
+			cumulative += count
+			continue
+		}
+		if i == len(h.Buckets) {
+			break
+		}
+		lower := time.Duration(0)
+		if i > 0 {
+			lower = h.Buckets[i-1]
+		}
+		fraction := (rank - float64(cumulative)) / float64(count)
+		return lower + time.Duration(fraction*float64(h.Buckets[i]-lower))
+	}
+	return h.Buckets[len(h.Buckets)-1]
+}
+
+// merge adds the counts of the other histogram to this one. The counts are only added if the buckets
+// are the same, since they can't be redistributed otherwise.
+func (h *Histogram) merge(other *Histogram) {
+	if len(h.Buckets) != len(other.Buckets) {
+		return
+	}
+	for i, b := range h.Buckets {
+		if other.Buckets[i] != b {
+			return
+		}
+	}
+	for i, c := range other.Counts {
+		h.Counts[i] += c
+	}
+}
@@ -0,0 +1,72 @@
+package timing
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Histogram(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	rootCtx.EnableHistogram(nil)
+	assert.Nil(t, rootCtx.HistogramCounts())
+
+	childCtx, childComplete := Start(rootCtx, "child")
+	childComplete()
+	childCtx.EnableHistogram([]time.Duration{100 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond})
+	for i := 0; i < 100; i++ {
+		_, childComplete = Start(rootCtx, "child")
+		switch {
+		case i < 50:
+			clock = clock.Add(5 * time.Millisecond)
+		case i < 95:
+			clock = clock.Add(30 * time.Millisecond)
+		case i < 99:
+			clock = clock.Add(80 * time.Millisecond)
+		default:
+			clock = clock.Add(time.Second)
+		}
+		childComplete()
+	}
+	rootComplete()
+
+	h := childCtx.HistogramCounts()
+	assert.Equal(t, []time.Duration{10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond}, h.Buckets)
+	assert.Equal(t, []uint64{50, 45, 4, 1}, h.Counts)
+	assert.Equal(t, uint64(100), h.Total())
+	assert.Equal(t, 10*time.Millisecond, h.Quantile(0.5))
+	assert.Equal(t, 45555555*time.Nanosecond, h.Quantile(0.9))
+	assert.Equal(t, 100*time.Millisecond, h.Quantile(0.99))
+	assert.Equal(t, 100*time.Millisecond, h.Quantile(1))
+
+	// The copy is independent of the live histogram.
+	h.Counts[0] = 0
+	assert.Equal(t, uint64(50), childCtx.HistogramCounts().Counts[0])
+
+	assert.Equal(t, "root > child - 2.92s calls: 101 (28.910891ms/call) (p50 10ms, p90 45.555555ms, p99 100ms)",
+		childCtx.Report(ReportOptions{Prefix: "root > "}))
+
+	b, err := json.Marshal(childCtx.Location)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"histogram":{"buckets":[10000000,50000000,100000000],"counts":[50,45,4,1]}`)
+	parsed, err := ParseReport(b)
+	assert.NoError(t, err)
+	assert.Equal(t, childCtx.HistogramCounts(), parsed.HistogramCounts())
+
+	b, err = childCtx.MarshalJSONWith(MarshalOptions{})
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"histogram":{"buckets":[10000000,50000000,100000000],"counts":[50,45,4,1]}`)
+
+	// Merging adds the counts of matching buckets.
+	merged := childCtx.Clone()
+	merged.Merge(childCtx.Location)
+	assert.Equal(t, []uint64{100, 90, 8, 2}, merged.HistogramCounts().Counts)
+
+	assert.Equal(t, time.Duration(0), (&Histogram{}).Quantile(0.5))
+}
@@ -2,6 +2,9 @@ package timing
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -9,7 +12,35 @@ import (
 )
 
 type Location struct {
-	mu sync.Mutex
+	// The unexported fields that are accessed with 64-bit atomic operations come first, since only the
+	// start of an allocated struct is guaranteed to be 64-bit aligned on 32-bit platforms. The fields
+	// before EntryCount add up to a multiple of 8 bytes on those platforms as well, which keeps it and
+	// the other exported counters and durations that follow it aligned without changing their order in
+	// the JSON representation. See Test_LocationAlignment.
+
+	// firstEntry is the time, in Unix nanoseconds, that this location was first started. It is zero
+	// if the location has never been started.
+	firstEntry int64
+
+	// lastEntry is the time, in Unix nanoseconds, that this location was most recently started.
+	lastEntry int64
+
+	// lastExit is the time, in Unix nanoseconds, that the last call of this location to end was
+	// completed. It is zero if the location has never been completed.
+	lastExit int64
+
+	// cancelled and deadlineExceeded count the calls that were completed after their context was done.
+	// See WithCancellationTracking.
+	cancelled        uint64
+	deadlineExceeded uint64
+
+	// mu guards the maps and slices of the location. Reads take the read lock, so that reporting on a
+	// tree that is still being timed doesn't contend with the timings.
+	mu sync.RWMutex
+
+	// parent is the location that this location is a child of. This is nil for roots. Since it is
+	// unexported it is never serialized, which prevents cycles when marshaling.
+	parent *Location
 
 	// Name is the name of this timing context. If empty this is the non-reporting root of the context.
 	Name string `json:"name,omitempty"`
@@ -17,91 +48,498 @@ type Location struct {
 	// Children has all the child timing contexts that have been started under this context.
 	Children map[string]*Location `json:"children,omitempty"`
 
-	// EntryCount is the number of times the timing context has been started.
-	EntryCount uint32 `json:"entry-count,omitempty"`
+	// EntryCount is the number of times the timing context has been started. This is updated
+	// atomically, so use Entries to read it while timings are being started on other Goroutines.
+	EntryCount uint64 `json:"entry-count,omitempty"`
 
-	// ExistCount is the number of times the timing context has been completed.
-	ExitCount uint32 `json:"exit-count,omitempty"`
+	// ExistCount is the number of times the timing context has been completed. Use Exits to read it
+	// while timings are being completed on other Goroutines.
+	ExitCount uint64 `json:"exit-count,omitempty"`
 
-	// TotalDuration is the amount of time this context has been started.
+	// TotalDuration is the amount of time this context has been started. This is updated atomically
+	// when a timing is completed, so reading it directly while timings are still being completed on
+	// other Goroutines is a data race. Use Duration to read it safely.
 	TotalDuration time.Duration `json:"total-duration,omitempty"`
 
+	// MinDuration is the duration of the fastest single call of this location. Like TotalDuration,
+	// this is updated atomically, so use MinCall to read it while timings are being completed. Since
+	// zero means that no call has been completed yet, a call that takes no time at all is not counted.
+	MinDuration time.Duration `json:"min-duration,omitempty"`
+
+	// MaxDuration is the duration of the slowest single call of this location. Use MaxCall to read it
+	// while timings are being completed.
+	MaxDuration time.Duration `json:"max-duration,omitempty"`
+
 	// Async, if set, causes the children's time to never be excluded. This is used in cases where
 	// you have either overlapping timing contexts. This is normally caused when multiple Goroutines
-	// are started in parallel in the same timing context.
+	// are started in parallel in the same timing context. Setting this directly is not safe while the
+	// tree is being timed or reported on by other Goroutines; StartAsync sets it under the lock.
 	Async bool `json:"async,omitempty"`
 
 	// Details allow you to add extra information about the timing location, so you can note the number
 	// of items processed or the number of attempts to access a resource.
 	Details map[string]anything `json:"details,omitempty"`
 
+	// NoteText is a free-form note for humans reading the report. See Note.
+	NoteText string `json:"note,omitempty"`
+
+	// Histogram counts the calls of this location by their duration, if that has been turned on with
+	// EnableHistogram. Use HistogramCounts to read it while timings are being completed.
+	Histogram *Histogram `json:"histogram,omitempty"`
+
+	// detailOrder is the order that the details were first added in.
+	detailOrder []string
+
 	// CallOrder is a list of the order that the timing contexts were started. This is useful for
 	// presenting the timing information in the order that it was executed.
 	CallOrder []string `json:"-"`
+
+	// Sections has the total time spent in each of the named sections of this location. See
+	// Context.Section.
+	Sections map[string]time.Duration `json:"sections,omitempty"`
+
+	// sectionOrder is the order that the sections were first completed in.
+	sectionOrder []string
+
+	// pathCache holds the cached full path of this location. See CachedPath.
+	pathCache atomic.Value
+
+	// origin is the call stack that first started this location. See CaptureOrigins.
+	origin []uintptr
+
+	// samples holds the most recent call durations if sample recording is on. See RecordSamples.
+	samples     []time.Duration
+	sampleNext  int
+	sampleLimit int32
+
+	// slowDetails are the details that are waiting to be evaluated when the current call completes.
+	slowDetails     []slowDetail
+	slowDetailCount int32
+
+	// samplingOneIn is the number of calls that one sampled call stands for. See SetSampling.
+	samplingOneIn   int32
+	samplingCounter uint32
+
+	// histogramEnabled is set if the Histogram is being kept, so that completing a call only takes the
+	// lock when it is.
+	histogramEnabled int32
+}
+
+// slowDetail is a detail that is only recorded if the call it was registered during is slow.
+type slowDetail struct {
+	key       string
+	threshold time.Duration
+	valueFn   func() anything
 }
 
 type anything interface{}
 
+// now returns the current time. This is replaceable so that tests can control the clock.
+var now = time.Now
+
 // Complete is a function to call when a concurrent execution is completed.
 type Complete func()
 
 // Start begins a timed event for this location. It returns a Complete function that is
 // to be called when whatever it is that is being timed is completed.
 func (l *Location) Start() Complete {
+	if l.isDisabled() {
+		return noopComplete
+	}
+	return l.start(nil)
+}
+
+// start begins a timed event for this location. If onComplete is specified, it is called with the
+// duration of the event once it has been recorded.
+func (l *Location) start(onComplete func(d time.Duration)) Complete {
+	weight := l.sampleWeight()
+	if weight == 0 {
+		return noopComplete
+	}
 	ended := false
-	atomic.AddUint32(&l.EntryCount, 1)
-	startTime := time.Now()
+	startTime := now()
+	l.recordEntry(startTime, weight)
 	return func() {
-		d := time.Since(startTime)
+		end := now()
+		d := end.Sub(startTime)
 		if ended {
 			panic("timing already completed")
 		}
 		ended = true
-		atomic.AddUint32(&l.ExitCount, 1)
-		atomic.AddInt64((*int64)(&l.TotalDuration), int64(d))
+		d = l.checkSuspend(d)
+		l.recordExit(end, d, weight)
+		if onComplete != nil {
+			onComplete(d)
+		}
+	}
+}
+
+// recordEntry records that a call of this location was started at startTime. The weight is the
+// number of calls that it stands for.
+func (l *Location) recordEntry(startTime time.Time, weight uint64) {
+	atomic.AddUint64(&l.EntryCount, weight)
+	atomic.StoreInt64(&l.lastEntry, startTime.UnixNano())
+	if atomic.LoadInt64(&l.firstEntry) == 0 {
+		if atomic.CompareAndSwapInt64(&l.firstEntry, 0, startTime.UnixNano()) && atomic.LoadInt32(&captureOrigins) != 0 {
+			l.captureOrigin()
+		}
+	}
+}
+
+// recordExit records that a call of this location that took d was completed at end. The weight is
+// the number of calls that it stands for.
+func (l *Location) recordExit(end time.Time, d time.Duration, weight uint64) {
+	atomic.AddUint64(&l.ExitCount, weight)
+	atomic.AddInt64((*int64)(&l.TotalDuration), int64(d)*int64(weight))
+	l.updateMinMax(d)
+	l.updateLastExit(end.UnixNano())
+	if atomic.LoadInt32(&l.sampleLimit) > 0 {
+		l.addSample(d)
+	}
+	if atomic.LoadInt32(&l.histogramEnabled) > 0 {
+		l.observeHistogram(d, weight)
+	}
+	if atomic.LoadInt32(&l.slowDetailCount) > 0 {
+		l.applySlowDetails(d)
 	}
 }
 
 func (l *Location) AddDetails(key string, value anything) {
+	if l.isDisabled() {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.setDetail(key, value)
+}
+
+// IncrementDetail adds delta to the integer detail with the key, starting from zero if the location
+// doesn't have it yet. Unlike reading the detail and setting it again with AddDetails, this is safe
+// for concurrent use, so it can be used to count things like the rows processed across many calls of
+// the location. The detail is stored as an int64. This panics if the detail exists and isn't an
+// integer.
+func (l *Location) IncrementDetail(key string, delta int64) {
+	if l.isDisabled() {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var current int64
+	switch v := l.Details[key].(type) {
+	case nil:
+	case int64:
+		current = v
+	case int:
+		current = int64(v)
+	case int32:
+		current = int64(v)
+	default:
+		panic("detail " + key + " is not an integer")
+	}
+	l.setDetail(key, current+delta)
+}
+
+// AddDetailDuration adds d to the duration detail with the key, starting from zero if the location
+// doesn't have it yet. Like IncrementDetail, this is safe for concurrent use. This is useful for
+// accumulating time that was measured elsewhere, such as the time spent waiting on a pool. This panics
+// if the detail exists and isn't a time.Duration.
+func (l *Location) AddDetailDuration(key string, d time.Duration) {
+	if l.isDisabled() {
+		return
+	}
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	var current time.Duration
+	switch v := l.Details[key].(type) {
+	case nil:
+	case time.Duration:
+		current = v
+	default:
+		panic("detail " + key + " is not a duration")
+	}
+	l.setDetail(key, current+d)
+}
+
+// addDetailMap adds all the details at once, in order by their keys.
+func (l *Location) addDetailMap(details map[string]interface{}) {
+	keys := make([]string, 0, len(details))
+	for key := range details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		l.setDetail(key, details[key])
+	}
+}
+
+// setDetail sets the detail while the lock is held.
+func (l *Location) setDetail(key string, value anything) {
 	if l.Details == nil {
 		l.Details = map[string]anything{}
 	}
+	if _, ok := l.Details[key]; !ok {
+		l.detailOrder = append(l.detailOrder, key)
+	}
 	l.Details[key] = value
 }
 
+// Note sets a free-form note on the location, such as "waiting on vendor API". Unlike details, which
+// are meant to hold data, a note is a single human-readable remark that is shown inline after the
+// location's timings: "name - 50ms // waiting on vendor API". Setting a note replaces any previous one.
+func (l *Location) Note(text string) {
+	if l.isDisabled() {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.NoteText = text
+}
+
+// isAsync returns whether the location is Async. This reads it under the lock, since StartAsync may
+// be setting it on another Goroutine.
+func (l *Location) isAsync() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.Async
+}
+
+// markAsync makes the location Async, unless it already is.
+func (l *Location) markAsync() {
+	if l.isAsync() {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.Async = true
+}
+
+// note returns the note of the location.
+func (l *Location) note() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.NoteText
+}
+
+// AddDetailIfSlow registers a detail that is only recorded if the call that is currently in progress
+// turns out to be slower than the threshold. Since the duration isn't known until the call is
+// completed, valueFn is evaluated by the Complete function, and only if the call was slow. This
+// allows details that are expensive to compute to be skipped for fast calls.
+//
+// The registered detail applies to the next completion of this location. If the location is being
+// timed concurrently, that may be a different call than the one that registered it.
+func (l *Location) AddDetailIfSlow(key string, threshold time.Duration, valueFn func() anything) {
+	if l.isDisabled() {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.slowDetails = append(l.slowDetails, slowDetail{
+		key:       key,
+		threshold: threshold,
+		valueFn:   valueFn,
+	})
+	atomic.AddInt32(&l.slowDetailCount, 1)
+}
+
+// applySlowDetails evaluates and records any pending slow details whose threshold was exceeded by
+// the duration of the call that just completed.
+func (l *Location) applySlowDetails(d time.Duration) {
+	l.mu.Lock()
+	pending := l.slowDetails
+	l.slowDetails = nil
+	atomic.AddInt32(&l.slowDetailCount, -int32(len(pending)))
+	l.mu.Unlock()
+
+	for _, sd := range pending {
+		if d > sd.threshold {
+			l.AddDetails(sd.key, sd.valueFn())
+		}
+	}
+}
+
+// Parent returns the location that this location is a child of, or nil if this is a root.
+func (l *Location) Parent() *Location {
+	return l.parent
+}
+
 // String returns a multi-line report of what time was spent and where it was spent.
 func (l *Location) String() string {
-	b := strings.Builder{}
-	l.dumpToBuilder(&b, "", &ReportOptions{Separator: " > "})
-	return b.String()
+	return l.Report(ReportOptions{})
+}
+
+// Duration returns the total amount of time this context has been started. Unlike reading
+// TotalDuration directly, this is safe to call while timings are being completed concurrently.
+func (l *Location) Duration() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&l.TotalDuration)))
+}
+
+// ReportedDuration returns the duration of this location as the reports show it. If excludeChildren is
+// set, the durations of the children are subtracted, unless the location is Async, just like with
+// ReportOptions.ExcludeChildren. This is the way to ask how long a location took with the same rules as
+// the reports, rather than reading TotalDuration. The result can be negative if the children add up to
+// more than the location itself, see SelfDuration for one that can't.
+func (l *Location) ReportedDuration(excludeChildren bool) time.Duration {
+	d := l.Duration()
+	if excludeChildren && !l.isAsync() {
+		d -= l.TotalChildDuration()
+	}
+	return d
+}
+
+// Entries returns the number of times this location has been started. Unlike reading EntryCount
+// directly, this is safe to call while the location is being timed concurrently.
+func (l *Location) Entries() uint64 {
+	return atomic.LoadUint64(&l.EntryCount)
+}
+
+// Exits returns the number of times this location has been completed. Unlike reading ExitCount
+// directly, this is safe to call while the location is being timed concurrently.
+func (l *Location) Exits() uint64 {
+	return atomic.LoadUint64(&l.ExitCount)
+}
+
+// Elapsed returns how long the most recently started call of this location has been running, without
+// completing it. This is useful for logging the progress of a long operation, such as in a heartbeat.
+// This returns zero if no call is in progress. If several calls are in progress concurrently, only the
+// most recently started one is considered. This is safe to call while the location is being timed.
+func (l *Location) Elapsed() time.Duration {
+	if atomic.LoadUint64(&l.EntryCount) == atomic.LoadUint64(&l.ExitCount) {
+		return 0
+	}
+	last := atomic.LoadInt64(&l.lastEntry)
+	if last == 0 {
+		return 0
+	}
+	return now().Sub(time.Unix(0, last))
+}
+
+// FirstEntry returns when this location was first started, or the zero time if it has never been
+// started. Together with LastExit, this shows when the location actually ran, which is useful for
+// correlating the timings with logs, especially for Async locations. This is safe to call while timings
+// are being completed concurrently.
+func (l *Location) FirstEntry() time.Time {
+	return unixNanoTime(atomic.LoadInt64(&l.firstEntry))
+}
+
+// LastExit returns when the last call of this location to end was completed, or the zero time if it
+// has never been completed. This is safe to call while timings are being completed concurrently.
+func (l *Location) LastExit() time.Time {
+	return unixNanoTime(atomic.LoadInt64(&l.lastExit))
+}
+
+// updateLastExit moves the last exit time forward to end, unless a later one has already been
+// recorded by a concurrent completion.
+func (l *Location) updateLastExit(end int64) {
+	for {
+		last := atomic.LoadInt64(&l.lastExit)
+		if last >= end || atomic.CompareAndSwapInt64(&l.lastExit, last, end) {
+			return
+		}
+	}
+}
+
+// unixNanoTime converts a time in Unix nanoseconds to a time.Time, with zero being the zero time.
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// MinCall returns the duration of the fastest single call of this location. This is safe to call
+// while timings are being completed concurrently.
+func (l *Location) MinCall() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&l.MinDuration)))
+}
+
+// MaxCall returns the duration of the slowest single call of this location. Unlike the samples kept
+// by RecordSamples, this is always tracked since it needs no storage. This is safe to call while
+// timings are being completed concurrently.
+func (l *Location) MaxCall() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&l.MaxDuration)))
+}
+
+// updateMinMax updates the fastest and slowest calls with the duration of a completed call.
+func (l *Location) updateMinMax(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	for {
+		current := atomic.LoadInt64((*int64)(&l.MinDuration))
+		if (current != 0 && int64(d) >= current) || atomic.CompareAndSwapInt64((*int64)(&l.MinDuration), current, int64(d)) {
+			break
+		}
+	}
+	for {
+		current := atomic.LoadInt64((*int64)(&l.MaxDuration))
+		if int64(d) <= current || atomic.CompareAndSwapInt64((*int64)(&l.MaxDuration), current, int64(d)) {
+			break
+		}
+	}
+}
+
+// SelfDuration returns the time spent in this location itself, outside of its children, which is what
+// the reports show with ReportOptions.ExcludeChildren. The children's durations are not subtracted if
+// the location is Async, since they overlap it, and the result is never negative.
+func (l *Location) SelfDuration() time.Duration {
+	return l.reportedDuration(&ReportOptions{ExcludeChildren: true, ClampNegative: true})
 }
 
 // TotalChildDuration is a helper that computes the total time that the child timing contexts have spent.
 func (l *Location) TotalChildDuration() time.Duration {
 	d := time.Duration(0)
-	for _, child := range l.Children {
-		d += child.TotalDuration
+	for _, child := range l.snapshotChildren() {
+		d += child.Duration()
 	}
 	return d
 }
 
 // Report generates a report of how much time was spent where.
 func (l *Location) Report(options ReportOptions) string {
-	if options.Separator == "" {
-		if options.Compact {
-			options.Separator = " | "
-		} else {
-			options.Separator = " > "
-		}
-	}
 	b := strings.Builder{}
-	l.dumpToBuilder(&b, "", &options)
+	_, _ = l.WriteReport(&b, options)
 	return b.String()
 }
 
+// WriteReport writes the same report that Report generates directly to w, which avoids building
+// the whole report in memory when it's going to a file or a network connection anyway. It returns
+// the number of bytes written and the first error that happened while writing. Nothing more is
+// written after an error.
+func (l *Location) WriteReport(w io.Writer, options ReportOptions) (int, error) {
+	options.applyDefaults()
+	options.root = l
+	rw := &reportWriter{w: w}
+	l.dumpToWriter(rw, "", 0, &options)
+	if options.SeparateAsync {
+		l.dumpAsyncSubtrees(rw, "", 0, &options)
+	}
+	return rw.n, rw.err
+}
+
+// ReportDeterministic generates a report like Report does, except that the children of every
+// location are sorted by name and the details are always sorted by key. This makes the output
+// independent of the order that things were executed in, which is useful for golden-file tests of
+// concurrent code. Any ChildLess or DetailsInOrder options are overridden.
+func (l *Location) ReportDeterministic(options ReportOptions) string {
+	options.ChildLess = func(a, b *Location) bool {
+		return a.Name < b.Name
+	}
+	options.DetailsInOrder = false
+	return l.Report(options)
+}
+
 // ReportMap takes the timings and formats them into a map keyed on the location names with the
 // value of the duration divided by the divisor. With a divisor of 1, the reported time is in the
 // native nanoseconds that the Duration keeps track of. This may be annoying to read, so you can
@@ -111,15 +549,67 @@ func (l *Location) Report(options ReportOptions) string {
 //   - divisor is the amount to divide the duration by to get the reported time.
 //   - excludeChildren will subtract out of the duration of the children when reporting
 //     the time.
+//
+// If the name of a location contains the separator, its key may be the same as that of another
+// location, in which case only the value of the one that was called last is kept. Use
+// ReportMapStrict to detect this.
 func (l *Location) ReportMap(separator string, divisor float64, excludeChildren bool) map[string]float64 {
 	result := map[string]float64{}
-	l.dumpToMap(result, separator, "", divisor, excludeChildren)
+	l.dumpEntries(func(key string, value float64) {
+		result[key] = value
+	}, separator, "", divisor, excludeChildren, l.parent == nil)
+	return result
+}
+
+// ReportMapStrict generates the same map as ReportMap, except that it returns an error if the keys of
+// two locations are the same, rather than silently keeping only one of them. This happens if the name
+// of a location contains the separator.
+func (l *Location) ReportMapStrict(separator string, divisor float64, excludeChildren bool) (map[string]float64, error) {
+	result := map[string]float64{}
+	var err error
+	l.dumpEntries(func(key string, value float64) {
+		if _, ok := result[key]; ok && err == nil {
+			err = fmt.Errorf("duplicate report key %q; a location name contains the separator %q", key, separator)
+		}
+		result[key] = value
+	}, separator, "", divisor, excludeChildren, l.parent == nil)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ReportEntry is a single location in the output of ReportSlice.
+type ReportEntry struct {
+	Key   string
+	Value float64
+}
+
+// ReportSlice reports the same keys and values as ReportMap does, but as a slice that is in the
+// order the locations were called in, depth-first. This makes it possible to render stable charts
+// without having to sort the keys.
+func (l *Location) ReportSlice(separator string, divisor float64, excludeChildren bool) []ReportEntry {
+	var result []ReportEntry
+	l.dumpEntries(func(key string, value float64) {
+		result = append(result, ReportEntry{Key: key, Value: value})
+	}, separator, "", divisor, excludeChildren, l.parent == nil)
 	return result
 }
 
 // getChild gets an existing timing context or creates a child timing context if one
 // does not exist.
 func (l *Location) getChild(ctx context.Context, name string) *Context {
+	if l.isDisabled() {
+		return disabledContext(ctx)
+	}
+	// Most calls are for children that already exist, so look for them under the read lock first.
+	l.mu.RLock()
+	cl, ok := l.Children[name]
+	l.mu.RUnlock()
+	if ok {
+		return newContext(ctx, cl)
+	}
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -128,20 +618,13 @@ func (l *Location) getChild(ctx context.Context, name string) *Context {
 	}
 
 	if cl, ok := l.Children[name]; ok {
-		return &Context{
-			prevCtx:  ctx,
-			Location: cl,
-		}
+		return newContext(ctx, cl)
 	} else {
-		cl := &Location{
-			Name: name,
-		}
-		cc := &Context{
-			prevCtx:  ctx,
-			Location: cl,
-		}
+		cl := newLocation(name)
+		cl.parent = l
+		cl.samplingOneIn = atomic.LoadInt32(&l.samplingOneIn)
 		l.Children[name] = cl
 		l.CallOrder = append(l.CallOrder, name)
-		return cc
+		return newContext(ctx, cl)
 	}
 }
@@ -0,0 +1,58 @@
+package timing
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ReportMarkdown generates the timings as a GitHub-flavored Markdown table, for pasting into pull
+// requests and issues. There is a row for each location that has been entered, with columns for its
+// name, duration, number of calls, and details. The names are indented with non-breaking spaces by
+// their depth so that the hierarchy is visible once the table is rendered. Any pipes in the names and
+// details are escaped.
+//
+// ExcludeChildren, DurationFormatter, SubtractOverhead, DetailsInOrder, DetailFormatters, ChildLess,
+// and SortBy are honored. The other options don't apply.
+func (l *Location) ReportMarkdown(options ReportOptions) string {
+	options.applyDefaults()
+	options.root = l
+	b := strings.Builder{}
+	b.WriteString("| Name | Duration | Calls | Details |\n")
+	b.WriteString("| --- | ---: | ---: | --- |\n")
+	l.writeMarkdownRows(&b, 0, &options)
+	return b.String()
+}
+
+// writeMarkdownRows recursively writes the table rows of a location and its descendants.
+func (l *Location) writeMarkdownRows(b *strings.Builder, depth int, options *ReportOptions) {
+	childDepth := depth
+	if l.Name != "" {
+		childDepth++
+		if l.Entries() > 0 {
+			n := l.copyNode()
+			var details []string
+			for _, k := range n.detailKeys(options.DetailsInOrder) {
+				details = append(details, k+": "+options.formatDetail(k, n.Details[k]))
+			}
+			b.WriteString("| ")
+			b.WriteString(strings.Repeat("&nbsp;&nbsp;", depth))
+			b.WriteString(markdownCell(l.effectiveName()))
+			b.WriteString(" | ")
+			b.WriteString(markdownCell(options.formatDuration(l.reportedDuration(options))))
+			b.WriteString(" | ")
+			b.WriteString(strconv.FormatUint(n.EntryCount, 10))
+			b.WriteString(" | ")
+			b.WriteString(markdownCell(strings.Join(details, ", ")))
+			b.WriteString(" |\n")
+		}
+	}
+	for _, c := range l.orderedChildren(options) {
+		c.writeMarkdownRows(b, childDepth, options)
+	}
+}
+
+// markdownCell escapes the text so that it stays within a single table cell.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
@@ -0,0 +1,40 @@
+package timing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ReportMarkdown(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, complete := Start(context.Background(), "root")
+	childCtx, childComplete := Start(rootCtx, "child")
+	leafCtx, leafComplete := Start(childCtx, "a|b")
+	leafComplete()
+	childComplete()
+	_, childComplete = Start(rootCtx, "child")
+	childComplete()
+	childCtx.AddDetails("table", "users")
+	childCtx.AddDetails("rows", 12)
+	complete()
+
+	rootCtx.TotalDuration = 100 * time.Millisecond
+	childCtx.TotalDuration = 60 * time.Millisecond
+	leafCtx.TotalDuration = 1500 * time.Microsecond
+
+	expected := `| Name | Duration | Calls | Details |
+| --- | ---: | ---: | --- |
+| root | 100ms | 1 |  |
+| &nbsp;&nbsp;child | 60ms | 2 | table: users, rows: 12 |
+| &nbsp;&nbsp;&nbsp;&nbsp;a\|b | 2ms | 1 |  |
+`
+	assert.Equal(t, expected, rootCtx.ReportMarkdown(ReportOptions{
+		DetailsInOrder:    true,
+		DurationFormatter: AlignedFormatter(time.Millisecond, 0, 0),
+	}))
+}
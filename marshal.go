@@ -0,0 +1,247 @@
+package timing
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// MarshalOptions controls the JSON that MarshalJSONWith generates, much like ReportOptions does for
+// the text reports.
+type MarshalOptions struct {
+	// MaxDepth limits the number of levels of the tree that are included. The location that is being
+	// marshaled is the first level, so a MaxDepth of 1 leaves out all of its children. If this is not
+	// specified the whole tree is included.
+	MaxDepth int
+
+	// ExcludeDetails leaves out the details of every location.
+	ExcludeDetails bool
+
+	// MinDuration leaves out the descendants whose total duration is less than this, along with
+	// their children. The location that is being marshaled is always included.
+	MinDuration time.Duration
+
+	// DurationsAsStrings renders the durations as formatted strings, such as "1.5s", instead of as
+	// numbers of nanoseconds.
+	DurationsAsStrings bool
+
+	// IncludeSelfDuration adds a "self-duration" to every location next to its "total-duration".
+	// This is the SelfDuration of the location: the time spent in the location itself, excluding its
+	// children unless it is Async, which is never negative. Having both allows one document to feed
+	// both inclusive and exclusive visualizations, such as flame graphs and icicle charts.
+	IncludeSelfDuration bool
+
+	// IncludeCallOrder adds a "call-order" to every location that has children, listing their names
+	// in the order that they were first called, and a "detail-order" to every location that has
+	// details, listing their keys in the order that they were first added. ParseReport uses these to
+	// restore the orders, so that the reports of the parsed tree are the same as those of the original,
+	// including ones with ReportOptions.DetailsInOrder.
+	IncludeCallOrder bool
+}
+
+// marshalNode is the JSON representation of a location that MarshalJSONWith generates. It matches
+// the default JSON representation of a Location.
+type marshalNode struct {
+	Name          string                  `json:"name,omitempty"`
+	Children      map[string]*marshalNode `json:"children,omitempty"`
+	EntryCount    uint64                  `json:"entry-count,omitempty"`
+	ExitCount     uint64                  `json:"exit-count,omitempty"`
+	TotalDuration anything                `json:"total-duration,omitempty"`
+	SelfDuration  anything                `json:"self-duration,omitempty"`
+	MinDuration   anything                `json:"min-duration,omitempty"`
+	MaxDuration   anything                `json:"max-duration,omitempty"`
+	Async         bool                    `json:"async,omitempty"`
+	Details       map[string]anything     `json:"details,omitempty"`
+	NoteText      string                  `json:"note,omitempty"`
+	Sections      map[string]anything     `json:"sections,omitempty"`
+	Histogram     *Histogram              `json:"histogram,omitempty"`
+	FirstEntry    *time.Time              `json:"first-entry,omitempty"`
+	LastExit      *time.Time              `json:"last-exit,omitempty"`
+	Cancelled     uint64                  `json:"cancelled,omitempty"`
+	Deadline      uint64                  `json:"deadline-exceeded,omitempty"`
+	CallOrder     []string                `json:"call-order,omitempty"`
+	DetailOrder   []string                `json:"detail-order,omitempty"`
+}
+
+// MarshalJSONWith generates the JSON representation of the timing tree like json.Marshal does, but
+// with the options applied. This is useful when the JSON is sent somewhere with limited space.
+func (l *Location) MarshalJSONWith(opts MarshalOptions) ([]byte, error) {
+	return json.Marshal(l.Clone().toMarshalNode(&opts, 1))
+}
+
+// toMarshalNode recursively converts the location to its JSON representation. The depth is the level
+// of this location, starting at 1. This is called on a Clone of the tree, so that the self duration
+// of each location is consistent with the durations of it and its children that are marshaled.
+func (l *Location) toMarshalNode(opts *MarshalOptions, depth int) *marshalNode {
+	c := l.copyNode()
+	n := &marshalNode{
+		Name:       c.Name,
+		EntryCount: c.EntryCount,
+		ExitCount:  c.ExitCount,
+		Async:      c.Async,
+		NoteText:   c.NoteText,
+		Histogram:  c.Histogram,
+		FirstEntry: jsonTime(c.FirstEntry()),
+		LastExit:   jsonTime(c.LastExit()),
+		Cancelled:  c.cancelled,
+		Deadline:   c.deadlineExceeded,
+	}
+	if c.TotalDuration != 0 {
+		n.TotalDuration = opts.formatDuration(c.TotalDuration)
+	}
+	if c.MinDuration != 0 {
+		n.MinDuration = opts.formatDuration(c.MinDuration)
+	}
+	if c.MaxDuration != 0 {
+		n.MaxDuration = opts.formatDuration(c.MaxDuration)
+	}
+	if opts.IncludeSelfDuration {
+		n.SelfDuration = opts.formatDuration(l.SelfDuration())
+	}
+	if !opts.ExcludeDetails && len(c.Details) > 0 {
+		n.Details = c.Details
+		if opts.IncludeCallOrder {
+			n.DetailOrder = c.detailOrder
+		}
+	}
+	if len(c.Sections) > 0 {
+		n.Sections = make(map[string]anything, len(c.Sections))
+		for k, v := range c.Sections {
+			n.Sections[k] = opts.formatDuration(v)
+		}
+	}
+	if opts.MaxDepth > 0 && depth >= opts.MaxDepth {
+		return n
+	}
+	for _, child := range l.snapshotChildren() {
+		if child.Duration() < opts.MinDuration {
+			continue
+		}
+		if n.Children == nil {
+			n.Children = map[string]*marshalNode{}
+		}
+		n.Children[child.Name] = child.toMarshalNode(opts, depth+1)
+		if opts.IncludeCallOrder {
+			n.CallOrder = append(n.CallOrder, child.Name)
+		}
+	}
+	return n
+}
+
+// formatDuration returns the duration as it is to be marshaled.
+func (opts *MarshalOptions) formatDuration(d time.Duration) anything {
+	if opts.DurationsAsStrings {
+		return d.String()
+	}
+	return int64(d)
+}
+
+// ParseReport reconstructs a timing tree from its JSON representation, as generated by json.Marshal
+// or MarshalJSONWith with the durations as numbers. See Location.UnmarshalJSON for the details.
+func ParseReport(data []byte) (*Location, error) {
+	l := &Location{}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// locationJSON has the same fields as a Location, but without its MarshalJSON and UnmarshalJSON
+// methods.
+type locationJSON Location
+
+// MarshalJSON generates the JSON representation of the location from its fields. This also includes
+// when the location was first started and last completed, as "first-entry" and "last-exit", if it has
+// been, and the number of its calls that were cut short, as "cancelled" and "deadline-exceeded", if
+// any were. See WithCancellationTracking. Each location is copied under its lock before it is
+// marshaled, so this is safe to call on a tree that is still being timed.
+func (l *Location) MarshalJSON() ([]byte, error) {
+	c := l.copyNode()
+	for _, child := range l.snapshotChildren() {
+		if c.Children == nil {
+			c.Children = map[string]*Location{}
+		}
+		c.Children[child.Name] = child
+	}
+	return json.Marshal(struct {
+		*locationJSON
+		FirstEntry *time.Time `json:"first-entry,omitempty"`
+		LastExit   *time.Time `json:"last-exit,omitempty"`
+		Cancelled  uint64     `json:"cancelled,omitempty"`
+		Deadline   uint64     `json:"deadline-exceeded,omitempty"`
+	}{
+		locationJSON: (*locationJSON)(c),
+		FirstEntry:   jsonTime(c.FirstEntry()),
+		LastExit:     jsonTime(c.LastExit()),
+		Cancelled:    c.cancelled,
+		Deadline:     c.deadlineExceeded,
+	})
+}
+
+// jsonTime returns the time, in UTC, to be marshaled, or nil if it is the zero time so that it is
+// left out.
+func jsonTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// UnmarshalJSON reconstructs a location, and all of its descendants, from its JSON representation.
+// The parents of the children are restored. The order that the children were called in is restored
+// if the JSON has a "call-order", and the order that the details were added in is restored if it has a
+// "detail-order", see MarshalOptions.IncludeCallOrder. Otherwise, or for any children or details that
+// are missing from them, they are put in order by their names. The "first-entry" and "last-exit"
+// times, and the "cancelled" and "deadline-exceeded" counts, are restored, and details are restored as
+// the types that JSON decodes them to.
+func (l *Location) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*locationJSON)(l)); err != nil {
+		return err
+	}
+	var extra struct {
+		CallOrder   []string  `json:"call-order"`
+		DetailOrder []string  `json:"detail-order"`
+		FirstEntry  time.Time `json:"first-entry"`
+		LastExit    time.Time `json:"last-exit"`
+		Cancelled   uint64    `json:"cancelled"`
+		Deadline    uint64    `json:"deadline-exceeded"`
+	}
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return err
+	}
+	if !extra.FirstEntry.IsZero() {
+		l.firstEntry = extra.FirstEntry.UnixNano()
+	}
+	if !extra.LastExit.IsZero() {
+		l.lastExit = extra.LastExit.UnixNano()
+	}
+	l.cancelled = extra.Cancelled
+	l.deadlineExceeded = extra.Deadline
+
+	l.CallOrder = make([]string, 0, len(l.Children))
+	seen := map[string]bool{}
+	for _, name := range extra.CallOrder {
+		if _, ok := l.Children[name]; ok && !seen[name] {
+			l.CallOrder = append(l.CallOrder, name)
+			seen[name] = true
+		}
+	}
+	var rest []string
+	for name, child := range l.Children {
+		child.parent = l
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	l.CallOrder = append(l.CallOrder, rest...)
+	l.detailOrder = extra.DetailOrder
+	l.detailOrder = l.detailKeys(true)
+	l.sectionOrder = make([]string, 0, len(l.Sections))
+	for name := range l.Sections {
+		l.sectionOrder = append(l.sectionOrder, name)
+	}
+	sort.Strings(l.sectionOrder)
+	return nil
+}
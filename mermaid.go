@@ -0,0 +1,111 @@
+package timing
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MermaidKind selects the kind of diagram that Mermaid generates.
+type MermaidKind int
+
+const (
+	// MermaidGantt generates a gantt chart with a bar for each location, placed at the time it was
+	// first started and as long as its total duration.
+	MermaidGantt MermaidKind = iota
+
+	// MermaidFlowchart generates a top-down flowchart of the timing tree with the durations on the
+	// nodes.
+	MermaidFlowchart
+)
+
+// Mermaid generates a Mermaid diagram of the timing tree, which can be embedded in GitHub Markdown and
+// many documentation tools.
+func (l *Location) Mermaid(kind MermaidKind) string {
+	b := strings.Builder{}
+	switch kind {
+	case MermaidGantt:
+		l.writeMermaidGantt(&b)
+	case MermaidFlowchart:
+		b.WriteString("flowchart TD")
+		id := 0
+		l.writeMermaidFlowchart(&b, "", &id)
+	default:
+		panic("unknown mermaid kind")
+	}
+	return b.String()
+}
+
+// writeMermaidGantt writes the gantt chart. The times are relative to the earliest start in the tree.
+func (l *Location) writeMermaidGantt(b *strings.Builder) {
+	base := int64(0)
+	var findBase func(n *Location)
+	findBase = func(n *Location) {
+		if first := atomic.LoadInt64(&n.firstEntry); first != 0 && (base == 0 || first < base) {
+			base = first
+		}
+		for _, c := range n.snapshotChildren() {
+			findBase(c)
+		}
+	}
+	findBase(l)
+
+	b.WriteString("gantt\n")
+	b.WriteString("    dateFormat x\n")
+	b.WriteString("    axisFormat %S.%L")
+	if l.Name != "" {
+		b.WriteString("\n    title ")
+		b.WriteString(mermaidGanttText(l.Name))
+	}
+	var section string
+	var walk func(n *Location, path string)
+	walk = func(n *Location, path string) {
+		if n.Name != "" {
+			path += n.effectiveName()
+			top := strings.SplitN(path, defaultSeparator, 2)[0]
+			if top != section {
+				section = top
+				b.WriteString("\n    section ")
+				b.WriteString(mermaidGanttText(section))
+			}
+			if first := atomic.LoadInt64(&n.firstEntry); first != 0 {
+				start := time.Duration(first - base).Milliseconds()
+				b.WriteString(fmt.Sprintf("\n    %s :%d, %d", mermaidGanttText(path), start, start+n.Duration().Milliseconds()))
+			}
+			path += defaultSeparator
+		}
+		for _, c := range n.snapshotChildren() {
+			walk(c, path)
+		}
+	}
+	walk(l, "")
+}
+
+// writeMermaidFlowchart writes the nodes of the flowchart along with the edges from their parents.
+// The id is the next unused node ID.
+func (l *Location) writeMermaidFlowchart(b *strings.Builder, parentID string, id *int) {
+	nodeID := parentID
+	if l.Name != "" {
+		nodeID = fmt.Sprintf("n%d", *id)
+		*id++
+		b.WriteString(fmt.Sprintf("\n    %s[\"%s<br/>%s\"]", nodeID, mermaidLabel(l.effectiveName()), l.Duration()))
+		if parentID != "" {
+			b.WriteString(fmt.Sprintf("\n    %s --> %s", parentID, nodeID))
+		}
+	}
+	for _, c := range l.snapshotChildren() {
+		c.writeMermaidFlowchart(b, nodeID, id)
+	}
+}
+
+// mermaidGanttText makes text safe to use as a task or section name in a gantt chart, where colons,
+// semicolons, and hashes have special meanings.
+func mermaidGanttText(s string) string {
+	return strings.NewReplacer(":", " ", ";", " ", "#", " ", "\n", " ").Replace(s)
+}
+
+// mermaidLabel escapes text for a quoted flowchart label.
+func mermaidLabel(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "\n", " ").Replace(s)
+}
@@ -0,0 +1,60 @@
+package timing
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func buildMermaidTree(clock *time.Time) *Context {
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	*clock = clock.Add(10 * time.Millisecond)
+	_, child1Complete := Start(rootCtx, "child 1")
+	*clock = clock.Add(100 * time.Millisecond)
+	child1Complete()
+	child2Ctx, child2Complete := Start(rootCtx, "child: 2")
+	_, grandchildComplete := StartAsync(child2Ctx, "grandchild \"g\"")
+	*clock = clock.Add(100 * time.Millisecond)
+	grandchildComplete()
+	child2Complete()
+	rootComplete()
+	return rootCtx
+}
+
+func Test_MermaidGantt(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx := buildMermaidTree(&clock)
+	expected := `gantt
+    dateFormat x
+    axisFormat %S.%L
+    title root
+    section root
+    root :0, 210
+    root > child 1 :10, 110
+    root > child  2 :110, 210
+    root > child  2 > [grandchild "g"] :110, 210`
+	assert.Equal(t, expected, rootCtx.Mermaid(MermaidGantt))
+}
+
+func Test_MermaidFlowchart(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx := buildMermaidTree(&clock)
+	expected := `flowchart TD
+    n0["root<br/>210ms"]
+    n1["child 1<br/>100ms"]
+    n0 --> n1
+    n2["child: 2<br/>100ms"]
+    n0 --> n2
+    n3["[grandchild #quot;g#quot;]<br/>100ms"]
+    n2 --> n3`
+	assert.Equal(t, expected, rootCtx.Mermaid(MermaidFlowchart))
+
+	assert.PanicsWithValue(t, "unknown mermaid kind", func() {
+		rootCtx.Mermaid(MermaidKind(42))
+	})
+}
@@ -0,0 +1,59 @@
+package timing
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// NameCode is a location name that has been registered ahead of time with RegisterName. Starting a
+// timing context with a NameCode avoids building the name on every call, which matters for code that
+// is timed at a very high frequency.
+type NameCode int32
+
+var (
+	// nameCodeMu serializes the registration of names.
+	nameCodeMu sync.Mutex
+
+	// nameCodeNames holds the []string of registered names, indexed by their code. It is replaced as a
+	// whole whenever a name is registered, so it can be read without locking.
+	nameCodeNames atomic.Value
+)
+
+// RegisterName registers a name and returns the NameCode that refers to it. Registering the same name
+// again returns the same NameCode. This is intended to be called once for each name, typically when
+// initializing a package-level variable.
+func RegisterName(name string) NameCode {
+	if name == "" {
+		panic("non-root timings must be named")
+	}
+	nameCodeMu.Lock()
+	defer nameCodeMu.Unlock()
+
+	names, _ := nameCodeNames.Load().([]string)
+	for i, n := range names {
+		if n == name {
+			return NameCode(i)
+		}
+	}
+	updated := make([]string, len(names), len(names)+1)
+	copy(updated, names)
+	updated = append(updated, name)
+	nameCodeNames.Store(updated)
+	return NameCode(len(names))
+}
+
+// String returns the name that the code was registered for.
+func (c NameCode) String() string {
+	names, _ := nameCodeNames.Load().([]string)
+	if c < 0 || int(c) >= len(names) {
+		panic("unregistered name code")
+	}
+	return names[c]
+}
+
+// StartCode begins a timing context named by a registered NameCode. Other than how the name is
+// specified, this is the same as Start.
+func StartCode(ctx context.Context, code NameCode) (*Context, Complete) {
+	return Start(ctx, code.String())
+}
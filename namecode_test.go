@@ -0,0 +1,53 @@
+package timing
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_StartCode(t *testing.T) {
+	code := RegisterName("query")
+	assert.Equal(t, code, RegisterName("query"))
+	assert.NotEqual(t, code, RegisterName("other query"))
+	assert.Equal(t, "query", code.String())
+
+	rootCtx := Root(context.Background())
+	for i := 0; i < 3; i++ {
+		_, complete := StartCode(rootCtx, code)
+		complete()
+	}
+	assert.Equal(t, uint64(3), rootCtx.Children["query"].ExitCount)
+
+	assert.PanicsWithValue(t, "unregistered name code", func() {
+		StartCode(rootCtx, NameCode(1000000))
+	})
+	assert.PanicsWithValue(t, "non-root timings must be named", func() {
+		RegisterName("")
+	})
+}
+
+func Benchmark_StartString(b *testing.B) {
+	rootCtx := Root(context.Background())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, complete := Start(rootCtx, fmt.Sprintf("query-%d", i%4))
+		complete()
+	}
+}
+
+func Benchmark_StartCode(b *testing.B) {
+	codes := make([]NameCode, 4)
+	for i := range codes {
+		codes[i] = RegisterName(fmt.Sprintf("query-%d", i))
+	}
+	rootCtx := Root(context.Background())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, complete := StartCode(rootCtx, codes[i%4])
+		complete()
+	}
+}
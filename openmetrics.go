@@ -0,0 +1,110 @@
+package timing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OMOptions configures the output of OpenMetrics.
+type OMOptions struct {
+	// Name is the name of the metric family. If this is not specified the default is
+	// "timing_duration_seconds". The name should end in "_seconds" since that is the unit of the
+	// reported values.
+	Name string
+
+	// Help is the help text of the metric family. If this is not specified a generic description is used.
+	Help string
+
+	// Separator is used between the levels of the path label. If this is not specified the default
+	// is " > ".
+	Separator string
+
+	// ExcludeChildren controls if the child durations are subtracted from each location's duration,
+	// with the same rules as ReportOptions.ExcludeChildren. Since a histogram's sum can't be negative,
+	// a location whose children add up to more than itself is reported as zero.
+	ExcludeChildren bool
+
+	// TraceIDDetail is the key of a detail that holds a trace ID. If it is specified, the locations
+	// that have this detail are given an exemplar with the trace ID so that the metric can be linked
+	// to the trace. The exemplar's value is the average duration of a call.
+	TraceIDDetail string
+}
+
+// OpenMetrics formats the timings in the OpenMetrics text exposition format. Every location that has
+// been entered becomes a set of samples of a single histogram family, labeled with the location's
+// path. Since individual call durations are not kept, the histogram only has the "+Inf" bucket, which
+// gives the call count and total duration of each location. No dependencies are needed to produce
+// this, so the output can be served directly from a metrics endpoint.
+func (l *Location) OpenMetrics(opts OMOptions) string {
+	if opts.Name == "" {
+		opts.Name = "timing_duration_seconds"
+	}
+	if opts.Help == "" {
+		opts.Help = "Time spent in each timing location."
+	}
+	if opts.Separator == "" {
+		opts.Separator = defaultSeparator
+	}
+
+	b := strings.Builder{}
+	b.WriteString("# TYPE " + opts.Name + " histogram\n")
+	b.WriteString("# UNIT " + opts.Name + " seconds\n")
+	b.WriteString("# HELP " + opts.Name + " " + escapeOMHelp(opts.Help) + "\n")
+	l.dumpOpenMetrics(&b, "", &opts)
+	b.WriteString("# EOF\n")
+	return b.String()
+}
+
+// dumpOpenMetrics recursively writes the samples of each location.
+func (l *Location) dumpOpenMetrics(b *strings.Builder, path string, opts *OMOptions) {
+	childPrefix := path
+	if l.Name != "" {
+		path += l.Name
+		childPrefix = path + opts.Separator
+		if l.Entries() > 0 {
+			labels := `{path="` + escapeOMLabel(path) + `"`
+			count := strconv.FormatUint(uint64(l.Exits()), 10)
+			seconds := l.reportedDuration(&ReportOptions{ExcludeChildren: opts.ExcludeChildren, ClampNegative: true}).Seconds()
+
+			b.WriteString(opts.Name + "_bucket" + labels + `,le="+Inf"} ` + count)
+			if opts.TraceIDDetail != "" {
+				b.WriteString(l.formatExemplar(opts.TraceIDDetail, seconds))
+			}
+			b.WriteString("\n")
+			b.WriteString(opts.Name + "_count" + labels + "} " + count + "\n")
+			b.WriteString(opts.Name + "_sum" + labels + "} " + formatOMFloat(seconds) + "\n")
+		}
+	}
+	for _, c := range l.snapshotChildren() {
+		c.dumpOpenMetrics(b, childPrefix, opts)
+	}
+}
+
+// formatExemplar formats an exemplar with the trace ID from the detail, or returns an empty string
+// if the location doesn't have the detail.
+func (l *Location) formatExemplar(detail string, seconds float64) string {
+	l.mu.RLock()
+	traceID, ok := l.Details[detail]
+	l.mu.RUnlock()
+	if !ok || l.Exits() == 0 {
+		return ""
+	}
+	value := seconds / float64(l.Exits())
+	return ` # {trace_id="` + escapeOMLabel(fmt.Sprint(traceID)) + `"} ` + formatOMFloat(value)
+}
+
+// formatOMFloat formats a float for OpenMetrics.
+func formatOMFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// escapeOMLabel escapes a label value for OpenMetrics.
+func escapeOMLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// escapeOMHelp escapes help text for OpenMetrics.
+func escapeOMHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
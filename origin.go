@@ -0,0 +1,73 @@
+package timing
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// originDepth is the maximum number of stack frames that are captured for the origin of a location.
+const originDepth = 8
+
+// captureOrigins is non-zero if the origins of locations are to be captured.
+var captureOrigins int32
+
+// CaptureOrigins controls whether a short stack trace is captured when a location is started for
+// the first time. This is off by default since capturing a stack trace is expensive compared to the
+// rest of the timing. Turning it on helps track down where unexpected locations, especially ones
+// with dynamically generated names, are coming from.
+func CaptureOrigins(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&captureOrigins, v)
+}
+
+// captureOrigin records the call stack of the caller of Location.Start as the origin of the location.
+func (l *Location) captureOrigin() {
+	pcs := make([]uintptr, originDepth)
+	// Skip runtime.Callers, captureOrigin, recordEntry, and start
+	n := runtime.Callers(4, pcs)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.origin = pcs[:n]
+}
+
+// Origin returns the program counters of the call stack that first started this location. This is
+// only captured if CaptureOrigins is enabled at the time the location is first started, otherwise
+// this returns nil. Use runtime.CallersFrames to symbolize it, or OriginString for a readable form.
+func (l *Location) Origin() []uintptr {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.origin
+}
+
+// OriginString returns the call stack that first started this location with one frame per line in
+// the form "function (file:line)". This is empty if no origin was captured.
+func (l *Location) OriginString() string {
+	pcs := l.Origin()
+	if len(pcs) == 0 {
+		return ""
+	}
+	b := strings.Builder{}
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(frame.Function)
+		b.WriteString(" (")
+		b.WriteString(frame.File)
+		b.WriteString(":")
+		b.WriteString(strconv.Itoa(frame.Line))
+		b.WriteString(")")
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
@@ -2,8 +2,11 @@ package timing
 
 import (
 	"fmt"
+	"io"
+	"math"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,6 +24,11 @@ type ReportOptions struct {
 	// Golang time.Duration String() is called.
 	DurationFormatter DurationFormatter
 
+	// Round, if specified, rounds every duration in the report to a multiple of it, e.g. a
+	// millisecond reports 1.234567ms as 1ms. The rounding is done before the duration is passed to
+	// the DurationFormatter.
+	Round time.Duration
+
 	// ExcludeChildren controls if the child durations are subtracted from this duration or
 	// not. If the Location is marked as Async then the child durations are not subtracted out
 	// for that level.
@@ -29,118 +37,727 @@ type ReportOptions struct {
 	// Compact controls if the full path is output for each line or if levels are implied
 	// with indents. This makes for a far smaller output for deep timing trees.
 	Compact bool
+
+	// SubtractOverhead removes the calibrated cost of the timer itself (see CalibrateOverhead) from
+	// every completed call of each location. The result is clamped to zero. This is mostly useful
+	// when timing very fast operations where the timer's overhead would otherwise dominate.
+	SubtractOverhead bool
+
+	// WarnAsyncMisuse annotates locations that are not marked as Async, but whose children's
+	// durations add up to more than their own. This is almost always caused by children that run
+	// concurrently, which means the location should have been marked as Async. Without that, the
+	// duration reported with ExcludeChildren is negative.
+	WarnAsyncMisuse bool
+
+	// ClampNegative reports a duration of zero, instead of a negative one, for the locations whose
+	// children's durations add up to more than their own when ExcludeChildren is specified. Such
+	// locations are annotated with how much their children exceed them by, e.g.
+	// "root - 0s (clamped; children exceed by 90ms)", since this usually means that the location
+	// should have been marked as Async.
+	ClampNegative bool
+
+	// DetailsInOrder renders the details in the order they were added in, rather than sorted
+	// alphabetically by their keys. Any details that were not added with AddDetails are rendered
+	// after those, sorted by their keys.
+	DetailsInOrder bool
+
+	// ChildLess, if specified, controls the order that the children of each location are reported
+	// in, instead of the order that they were called in. It should return true if a is to be
+	// reported before b. Children that compare as equal are kept in call order.
+	ChildLess func(a, b *Location) bool
+
+	// SeparateAsync moves the Async locations out of the main report. The synchronous part of the
+	// tree is reported first, followed by each Async subtree under a "--- async: [name] ---"
+	// divider. This keeps the overlapping concurrent work from interrupting the serial flow of the
+	// report. Async locations nested within an Async subtree are reported with that subtree.
+	SeparateAsync bool
+
+	// ShowParallelism annotates each Async location with its EffectiveParallelism, e.g.
+	// "[worker] - 110ms (≈2.7x parallel)".
+	ShowParallelism bool
+
+	// HideNotes leaves out the notes that were added to the locations with Note. Notes are shown
+	// by default.
+	HideNotes bool
+
+	// ConfidenceLevel, if specified, annotates the locations that have recorded samples (see
+	// RecordSamples) with a confidence interval for their mean call duration at this level, e.g.
+	// 0.95 shows "(95% CI: 45ms–55ms)".
+	ConfidenceLevel float64
+
+	// ShowMaxCall annotates the locations that have been called more than once with the duration of
+	// their slowest single call, e.g. "(max 80ms)".
+	ShowMaxCall bool
+
+	// Explain annotates each location that has children with how its reported duration was
+	// arrived at, e.g. "root - 10ms (self; total 210ms − children 200ms)". This is helpful for
+	// understanding how ExcludeChildren and Async affect the numbers.
+	Explain bool
+
+	// ShowSiblingRank annotates each location that has siblings with its rank among them, slowest
+	// first, and how many times slower it is than the fastest of them, e.g.
+	// "child 1 - 100ms (1/2, 2.0× fastest)". Only siblings that have been entered are ranked.
+	ShowSiblingRank bool
+
+	// ShowMinMax annotates the locations that have been called more than once with the durations of
+	// their fastest and slowest single calls, e.g. "(min 5ms, max 80ms)". This supersedes ShowMaxCall.
+	ShowMinMax bool
+
+	// SortBy controls the order that the children of each location are reported in. The default
+	// is the order that they were called in. This is ignored if ChildLess is specified.
+	SortBy SortOrder
+
+	// MaxDepth limits the number of levels of the tree that are reported. The levels are counted
+	// from the location that the report is generated for, which is the first level unless it is an
+	// unnamed root. The locations at the last level that is reported are annotated with the number of
+	// descendants that were left out, e.g. "(+3 deeper)". Their durations still include the time of
+	// those descendants, as always. If this is not specified the whole tree is reported.
+	MaxDepth int
+
+	// MinDuration leaves out the locations whose reported duration is less than this. The children of
+	// a location that is left out are still reported if they are slow enough on their own. The first
+	// level of the report is never left out, so that the report is never empty.
+	MinDuration time.Duration
+
+	// ShowPercentages annotates each location with the share of the total duration of its parent and
+	// of the location that the report is generated for, e.g. "(42% of parent, 18% of total)". Any
+	// share of a zero duration is left out.
+	ShowPercentages bool
+
+	// Color highlights the duration of each location with ANSI escape codes by its share of the total
+	// duration of the report: red for half or more, yellow for a tenth or more, and dim for less than
+	// a hundredth. This is meant for printing to a terminal, so it should only be enabled when the
+	// output is one.
+	Color bool
+
+	// DetailFormatters, if specified, formats the details whose keys are in the map with their
+	// formatter, e.g. FormatBytes for a byte count. Other details that are a time.Duration are
+	// formatted with the DurationFormatter, and anything else with fmt's "%+v".
+	DetailFormatters map[string]DetailFormatter
+
+	// TreeStyle draws the report as a tree with box-drawing connectors, like tree(1) does, instead of
+	// repeating the path of each location. Multi-line details are aligned under the name of their
+	// location. This overrides Compact and Separator. With SeparateAsync, each Async subtree is drawn
+	// as a tree of its own.
+	//
+	//	root - 100ms
+	//	├── child 1 - 40ms
+	//	│   └── leaf - 30ms
+	//	└── child 2 - 60ms
+	TreeStyle bool
+
+	// HideCounts leaves out the call counts and per-call durations, e.g. " calls: 2 (50ms/call)", as
+	// well as the entry and exit counts of timings that are still in progress, so that only the names
+	// and durations are reported. This only affects the report, not the timings themselves.
+	HideCounts bool
+
+	// AlwaysShowCalls shows the call count and the per-call duration of every location, even those
+	// that were only called once, e.g. "child - 50ms calls: 1 (50ms/call)". This keeps the layout
+	// of each line the same, which makes the report easier to parse. HideCounts overrides this.
+	AlwaysShowCalls bool
+
+	// HideUnentered leaves out the locations that have never been started and that don't have any
+	// descendants that have been, such as those that ForName returned but that were never started.
+	// See Location.Prune to remove them from the tree instead.
+	HideUnentered bool
+
+	// root is the location that the report is being generated for.
+	root *Location
+}
+
+// The ANSI escape codes that are used by the Color option.
+const (
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiDim    = "\x1b[2m"
+	ansiReset  = "\x1b[0m"
+)
+
+// SortOrder is the order that the children of each location are reported in.
+type SortOrder int
+
+const (
+	// SortByCallOrder reports the children in the order that they were first called in.
+	SortByCallOrder SortOrder = iota
+
+	// SortByDurationDesc reports the slowest children first, by their total duration. Children with
+	// the same duration are kept in call order.
+	SortByDurationDesc
+
+	// SortByName reports the children sorted by their names.
+	SortByName
+)
+
+// less returns the comparison function for the sort order, or nil for the call order.
+func (o SortOrder) less() func(a, b *Location) bool {
+	switch o {
+	case SortByDurationDesc:
+		return func(a, b *Location) bool {
+			return a.Duration() > b.Duration()
+		}
+	case SortByName:
+		return func(a, b *Location) bool {
+			return a.Name < b.Name
+		}
+	default:
+		return nil
+	}
+}
+
+// applyDefaults fills in the defaults for any options that are not specified.
+func (options *ReportOptions) applyDefaults() {
+	if options.Separator == "" {
+		if options.Compact {
+			options.Separator = " | "
+		} else {
+			options.Separator = defaultSeparator
+		}
+	}
+}
+
+// formatDuration formats a duration with the DurationFormatter if one is specified, or with the
+// default time.Duration String() otherwise.
+func (options *ReportOptions) formatDuration(d time.Duration) string {
+	if options.Round > 0 {
+		d = d.Round(options.Round)
+	}
+	if options.DurationFormatter == nil {
+		return d.String()
+	}
+	return options.DurationFormatter(d)
+}
+
+// totalDuration is the duration of the location that the report is being generated for, or that of
+// its children if it is an unnamed root.
+func (options *ReportOptions) totalDuration() time.Duration {
+	if options.root == nil {
+		return 0
+	}
+	if options.root.Name == "" {
+		return options.root.TotalChildDuration()
+	}
+	return options.root.Duration()
+}
+
+// colorDuration wraps the formatted duration in the ANSI color for its share of the total duration
+// of the report.
+func (options *ReportOptions) colorDuration(formatted string, d time.Duration) string {
+	total := options.totalDuration()
+	if total <= 0 {
+		return formatted
+	}
+	var code string
+	switch share := float64(d) / float64(total); {
+	case share >= 0.5:
+		code = ansiRed
+	case share >= 0.1:
+		code = ansiYellow
+	case share < 0.01:
+		code = ansiDim
+	default:
+		return formatted
+	}
+	return code + formatted + ansiReset
+}
+
+// reportWriter writes a report to an io.Writer, keeping track of the number of bytes that have been
+// written and the first error that happened. Once a write fails, the rest are skipped.
+type reportWriter struct {
+	w   io.Writer
+	n   int
+	err error
+}
+
+// WriteString writes the string unless a previous write has failed.
+func (w *reportWriter) WriteString(s string) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := io.WriteString(w.w, s)
+	w.n += n
+	w.err = err
+	return n, err
+}
+
+// Len returns the number of bytes that have been written.
+func (w *reportWriter) Len() int {
+	return w.n
 }
 
 // DurationFormatter is a function to format a reported duration in whatever way you need.
 type DurationFormatter func(d time.Duration) string
 
-// dumpToBuilder is an internal function that recursively outputs the contents of each location
-// to the string builder passed in.
-func (l *Location) dumpToBuilder(b *strings.Builder, path string, options *ReportOptions) {
+// DetailFormatter is a function to format the value of a detail in whatever way you need.
+type DetailFormatter func(value interface{}) string
+
+// FormatBytes is a DetailFormatter for a detail that is a count of bytes. It is formatted with the
+// decimal unit that keeps it readable, e.g. "4.2MB" for 4,200,000 bytes. Values that aren't integers
+// are formatted with fmt's "%+v".
+func FormatBytes(value interface{}) string {
+	var n float64
+	switch v := value.(type) {
+	case int:
+		n = float64(v)
+	case int32:
+		n = float64(v)
+	case int64:
+		n = float64(v)
+	case uint:
+		n = float64(v)
+	case uint32:
+		n = float64(v)
+	case uint64:
+		n = float64(v)
+	default:
+		return fmt.Sprintf("%+v", value)
+	}
+	units := []string{"B", "kB", "MB", "GB", "TB", "PB"}
+	i := 0
+	for math.Abs(n) >= 1000 && i < len(units)-1 {
+		n /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f%s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", n, units[i])
+}
+
+// formatDetail formats the value of the detail with the key with its DetailFormatter if one is
+// specified, with the DurationFormatter if it is a time.Duration, or with fmt's "%+v" otherwise.
+func (options *ReportOptions) formatDetail(key string, value anything) string {
+	if f, ok := options.DetailFormatters[key]; ok {
+		return f(value)
+	}
+	if d, ok := value.(time.Duration); ok {
+		return options.formatDuration(d)
+	}
+	return fmt.Sprintf("%+v", value)
+}
+
+// unitSuffixes are the suffixes of the units that AlignedFormatter supports.
+var unitSuffixes = map[time.Duration]string{
+	time.Nanosecond:  "ns",
+	time.Microsecond: "µs",
+	time.Millisecond: "ms",
+	time.Second:      "s",
+	time.Minute:      "m",
+	time.Hour:        "h",
+}
+
+// AlignedFormatter returns a DurationFormatter that always reports in the same unit, with the number
+// padded to width characters and precision digits after the decimal point, e.g. "  210.00ms" for a
+// unit of time.Millisecond, a width of 8, and a precision of 2. Since every duration is formatted to
+// the same width, they form a neat column in the report. Numbers too large for the width are not
+// truncated. The unit must be one of time.Nanosecond, time.Microsecond, time.Millisecond,
+// time.Second, time.Minute, or time.Hour.
+func AlignedFormatter(unit time.Duration, width, precision int) DurationFormatter {
+	suffix, ok := unitSuffixes[unit]
+	if !ok {
+		panic("unsupported unit for aligned formatter")
+	}
+	return func(d time.Duration) string {
+		return fmt.Sprintf("%*.*f%s", width, precision, float64(d)/float64(unit), suffix)
+	}
+}
+
+// dumpToWriter is an internal function that recursively outputs the contents of each location
+// to the writer passed in. The depth is the number of reported levels above this location.
+func (l *Location) dumpToWriter(b *reportWriter, path string, depth int, options *ReportOptions) {
+	allChildren := l.orderedChildren(options)
+	var children []*Location
+	for _, c := range allChildren {
+		if options.SeparateAsync && c.isAsync() {
+			continue
+		}
+		if options.HideUnentered && !c.entered() {
+			continue
+		}
+		children = append(children, c)
+	}
+
 	var childPrefix string
+	childDepth := depth
 	if l.Name == "" {
 		childPrefix = path
 	} else {
-		var effectiveName string
-		if l.Async {
-			effectiveName = "[" + l.Name + "]"
-		} else {
-			effectiveName = l.Name
-		}
+		effectiveName := l.effectiveName()
+		childDepth++
+		truncated := options.MaxDepth > 0 && childDepth >= options.MaxDepth && len(allChildren) > 0
+
+		hidden := options.MinDuration > 0 && depth > 0 && l.reportedDuration(options) < options.MinDuration
 
-		if l.EntryCount > 0 || len(l.Children) == 0 {
+		if (l.Entries() > 0 || len(allChildren) == 0 || truncated) && !hidden {
 			if b.Len() > 0 {
 				b.WriteString("\n")
 			}
 
-			reportDuration := l.TotalDuration
-			if options.ExcludeChildren && !l.Async {
-				reportDuration -= l.TotalChildDuration()
-			}
 			b.WriteString(options.Prefix)
 			b.WriteString(path)
 
 			b.WriteString(effectiveName)
 
-			b.WriteString(" - ")
-			if l.EntryCount > 0 {
-				if options.DurationFormatter == nil {
-					b.WriteString(reportDuration.String())
-				} else {
-					b.WriteString(options.DurationFormatter(reportDuration))
-				}
-				if l.EntryCount != l.ExitCount {
-					b.WriteString(fmt.Sprintf(" entries: %d exits: %d", l.EntryCount, l.ExitCount))
-				} else if l.ExitCount > 1 {
-					b.WriteString(fmt.Sprintf(" calls: %d", l.EntryCount))
-				}
-				if l.ExitCount > 1 {
-					perCallDuration := time.Duration(float64(reportDuration) / float64(l.ExitCount))
-					var fmtCallDuration string
-					if options.DurationFormatter == nil {
-						fmtCallDuration = perCallDuration.String()
-					} else {
-						fmtCallDuration = options.DurationFormatter(perCallDuration)
-					}
-					b.WriteString(fmt.Sprintf(" (%s/call)", fmtCallDuration))
+			l.writeTimings(b, options)
+			if truncated {
+				b.WriteString(fmt.Sprintf(" (+%d deeper)", l.countDescendants()))
+			}
+		}
+
+		switch {
+		case options.TreeStyle:
+			childPrefix = treeContinuation(path)
+		case options.Compact:
+			childPrefix = path + options.Separator
+		default:
+			childPrefix = path + effectiveName + options.Separator
+		}
+
+		if !hidden {
+			switch {
+			case options.TreeStyle:
+				// The details hang off a bar that leads to the children, if there are any.
+				bar := " "
+				if !truncated && len(children) > 0 {
+					bar = "│"
 				}
+				b.WriteString(l.formatDetails(options.Prefix+childPrefix+bar, options))
+			case options.Compact:
+				b.WriteString(l.formatDetails(options.Prefix+childPrefix, options))
+			default:
+				b.WriteString(l.formatDetails(options.Prefix, options))
+			}
+		}
+		if truncated {
+			return
+		}
+	}
+	for i, c := range children {
+		cp := childPrefix
+		if options.TreeStyle && l.Name != "" {
+			if i == len(children)-1 {
+				cp += "└── "
+			} else {
+				cp += "├── "
 			}
 		}
+		c.dumpToWriter(b, cp, childDepth, options)
+	}
+}
 
+// treeContinuation turns the connector at the end of the path of a location into the indentation
+// that its children are reported under with TreeStyle.
+func treeContinuation(path string) string {
+	if strings.HasSuffix(path, "├── ") {
+		return strings.TrimSuffix(path, "├── ") + "│   "
+	}
+	if strings.HasSuffix(path, "└── ") {
+		return strings.TrimSuffix(path, "└── ") + "    "
+	}
+	return path
+}
+
+// countDescendants returns the number of locations below this one.
+func (l *Location) countDescendants() int {
+	count := 0
+	for _, c := range l.snapshotChildren() {
+		count += 1 + c.countDescendants()
+	}
+	return count
+}
+
+// dumpAsyncSubtrees finds the Async locations that were left out of the main report because of the
+// SeparateAsync option and reports each of them under a divider.
+func (l *Location) dumpAsyncSubtrees(b *reportWriter, path string, depth int, options *ReportOptions) {
+	childPrefix := path
+	childDepth := depth
+	if l.Name != "" {
+		childDepth++
+		if options.MaxDepth > 0 && childDepth >= options.MaxDepth {
+			return
+		}
 		if options.Compact {
 			childPrefix = path + options.Separator
 		} else {
-			childPrefix = path + effectiveName + options.Separator
+			childPrefix = path + l.effectiveName() + options.Separator
 		}
+	}
+	for _, c := range l.orderedChildren(options) {
+		if !c.isAsync() {
+			c.dumpAsyncSubtrees(b, childPrefix, childDepth, options)
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(options.Prefix)
+		b.WriteString("--- async: ")
+		b.WriteString(c.effectiveName())
+		b.WriteString(" ---")
 
-		if options.Compact {
-			b.WriteString(l.formatDetails(options.Prefix + childPrefix))
+		subtreeOptions := *options
+		subtreeOptions.SeparateAsync = false
+		if options.TreeStyle {
+			// Each subtree is drawn as a tree of its own under its divider.
+			c.dumpToWriter(b, "", childDepth, &subtreeOptions)
 		} else {
-			b.WriteString(l.formatDetails(options.Prefix))
+			c.dumpToWriter(b, childPrefix, childDepth, &subtreeOptions)
+		}
+	}
+}
+
+// orderedChildren returns the children of the location in the order that they are to be reported.
+func (l *Location) orderedChildren(options *ReportOptions) []*Location {
+	children := l.snapshotChildren()
+	less := options.ChildLess
+	if less == nil {
+		less = options.SortBy.less()
+	}
+	if less != nil {
+		sort.SliceStable(children, func(i, j int) bool {
+			return less(children[i], children[j])
+		})
+	}
+	return children
+}
+
+// effectiveName is the name of the location as it is shown in the reports. Async locations are
+// shown in square brackets.
+func (l *Location) effectiveName() string {
+	if l.isAsync() {
+		return "[" + l.Name + "]"
+	}
+	return l.Name
+}
+
+// writeTimings writes the duration of the location along with its call statistics, annotations and note.
+func (l *Location) writeTimings(b io.StringWriter, options *ReportOptions) {
+	b.WriteString(" - ")
+	if l.Entries() > 0 {
+		l.writeStatistics(b, options)
+	}
+	if note := l.note(); note != "" && !options.HideNotes {
+		b.WriteString(" // ")
+		b.WriteString(note)
+	}
+}
+
+// writeStatistics writes the duration of a location that has been entered, along with its call
+// statistics and annotations.
+func (l *Location) writeStatistics(b io.StringWriter, options *ReportOptions) {
+	reportDuration := l.reportedDuration(options)
+	if options.Color {
+		b.WriteString(options.colorDuration(options.formatDuration(reportDuration), reportDuration))
+	} else {
+		b.WriteString(options.formatDuration(reportDuration))
+	}
+	if options.Explain {
+		b.WriteString(l.explainDuration(options))
+	}
+	if !options.HideCounts {
+		l.writeCounts(b, reportDuration, options)
+	}
+	if options.ShowSiblingRank {
+		b.WriteString(l.formatSiblingRank(reportDuration, options))
+	}
+	if options.ShowPercentages {
+		b.WriteString(l.formatPercentages(options))
+	}
+	if options.ShowMinMax && l.Exits() > 1 {
+		b.WriteString(fmt.Sprintf(" (min %s, max %s)", options.formatDuration(l.MinCall()), options.formatDuration(l.MaxCall())))
+	} else if options.ShowMaxCall && l.Exits() > 1 {
+		b.WriteString(fmt.Sprintf(" (max %s)", options.formatDuration(l.MaxCall())))
+	}
+	if h := l.HistogramCounts(); h != nil && h.Total() > 0 {
+		b.WriteString(fmt.Sprintf(" (p50 %s, p90 %s, p99 %s)",
+			options.formatDuration(h.Quantile(0.5)), options.formatDuration(h.Quantile(0.9)), options.formatDuration(h.Quantile(0.99))))
+	}
+	b.WriteString(l.formatSections(options))
+	if options.ConfidenceLevel > 0 {
+		b.WriteString(l.formatConfidenceInterval(options))
+	}
+	if options.ShowParallelism && l.isAsync() {
+		b.WriteString(fmt.Sprintf(" (≈%.1fx parallel)", l.EffectiveParallelism()))
+	}
+	if options.ClampNegative && options.ExcludeChildren && !l.isAsync() {
+		if excess := l.TotalChildDuration() - l.Duration(); excess > 0 {
+			b.WriteString(fmt.Sprintf(" (clamped; children exceed by %s)", options.formatDuration(excess)))
+		}
+	}
+	b.WriteString(l.formatCancellations())
+	if options.WarnAsyncMisuse && !l.isAsync() && l.TotalChildDuration() > l.Duration() {
+		b.WriteString(" [children exceed parent — should this be Async?]")
+	}
+}
+
+// writeCounts writes the call count and the per-call duration of the location, or its entry and
+// exit counts if they don't match.
+func (l *Location) writeCounts(b io.StringWriter, reportDuration time.Duration, options *ReportOptions) {
+	if l.Entries() != l.Exits() {
+		b.WriteString(fmt.Sprintf(" entries: %d exits: %d", l.Entries(), l.Exits()))
+	} else if l.Exits() > 1 || options.AlwaysShowCalls {
+		b.WriteString(fmt.Sprintf(" calls: %d", l.Entries()))
+	}
+	if l.Exits() > 1 || (options.AlwaysShowCalls && l.Exits() > 0) {
+		perCallDuration := time.Duration(float64(reportDuration) / float64(l.Exits()))
+		b.WriteString(fmt.Sprintf(" (%s/call)", options.formatDuration(perCallDuration)))
+	}
+}
+
+// explainDuration describes how the reported duration of a location with children is computed.
+func (l *Location) explainDuration(options *ReportOptions) string {
+	if len(l.snapshotChildren()) == 0 {
+		return ""
+	}
+	var overhead string
+	if options.SubtractOverhead {
+		overhead = " − overhead " + options.formatDuration(CalibrateOverhead()*time.Duration(l.Exits()))
+	}
+	switch {
+	case !options.ExcludeChildren:
+		return fmt.Sprintf(" (total, including children%s)", overhead)
+	case l.isAsync():
+		return fmt.Sprintf(" (total; children not subtracted since async%s)", overhead)
+	default:
+		return fmt.Sprintf(" (self; total %s − children %s%s)",
+			options.formatDuration(l.Duration()), options.formatDuration(l.TotalChildDuration()), overhead)
+	}
+}
+
+// formatPercentages formats the share of the duration of the parent and the root that the location
+// accounts for.
+func (l *Location) formatPercentages(options *ReportOptions) string {
+	total := options.totalDuration()
+	var parts []string
+	if l != options.root && l.parent != nil && l.parent.Name != "" {
+		if pd := l.parent.Duration(); pd > 0 {
+			parts = append(parts, fmt.Sprintf("%.0f%% of parent", 100*float64(l.Duration())/float64(pd)))
+		}
+	}
+	if total > 0 {
+		parts = append(parts, fmt.Sprintf("%.0f%% of total", 100*float64(l.Duration())/float64(total)))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+// formatSiblingRank formats the rank of the location among its siblings, or nothing if it has none.
+func (l *Location) formatSiblingRank(reportDuration time.Duration, options *ReportOptions) string {
+	if l.parent == nil {
+		return ""
+	}
+	rank, count := 1, 0
+	fastest := time.Duration(-1)
+	for _, sibling := range l.parent.snapshotChildren() {
+		if atomic.LoadUint64(&sibling.EntryCount) == 0 {
+			continue
+		}
+		count++
+		d := reportDuration
+		if sibling != l {
+			d = sibling.reportedDuration(options)
 		}
+		if d > reportDuration {
+			rank++
+		}
+		if fastest < 0 || d < fastest {
+			fastest = d
+		}
+	}
+	if count < 2 {
+		return ""
 	}
-	for _, k := range l.CallOrder {
-		l := l.Children[k]
-		l.dumpToBuilder(b, childPrefix, options)
+	if fastest <= 0 {
+		return fmt.Sprintf(" (%d/%d)", rank, count)
 	}
+	return fmt.Sprintf(" (%d/%d, %.1f× fastest)", rank, count, float64(reportDuration)/float64(fastest))
 }
 
-// dumpToMap is an internal function that recursively outputs the contents of each location
-// to the map builder passed in.
-func (l *Location) dumpToMap(m map[string]float64, separator, path string, divisor float64, excludeChildren bool) {
+// detailKeys returns the keys of the details in the order that they are to be rendered. The lock must
+// be held, unless the location is a copy.
+func (l *Location) detailKeys(inOrder bool) []string {
+	keys := make([]string, 0, len(l.Details))
+	seen := map[string]bool{}
+	if inOrder {
+		for _, k := range l.detailOrder {
+			if _, ok := l.Details[k]; ok && !seen[k] {
+				keys = append(keys, k)
+				seen[k] = true
+			}
+		}
+	}
+	var rest []string
+	for k := range l.Details {
+		if !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// reportedDuration computes the duration that is reported for this location, taking into account
+// whether children are excluded and whether the timer overhead is subtracted.
+func (l *Location) reportedDuration(options *ReportOptions) time.Duration {
+	d := l.ReportedDuration(options.ExcludeChildren)
+	if options.ClampNegative && d < 0 {
+		d = 0
+	}
+	if options.SubtractOverhead {
+		d -= CalibrateOverhead() * time.Duration(l.Exits())
+		if d < 0 {
+			d = 0
+		}
+	}
+	return d
+}
+
+// dumpEntries is an internal function that recursively emits the key and value of each location in
+// call order. If useCache is set, the reporting root is the root of the whole tree, so the cached
+// paths of the locations can be used as the keys.
+func (l *Location) dumpEntries(emit func(key string, value float64), separator, path string, divisor float64, excludeChildren, useCache bool) {
 	var childPrefix string
 	if l.Name == "" {
 		childPrefix = path
 	} else {
-		reportDuration := l.TotalDuration
-		if excludeChildren && !l.Async {
-			reportDuration -= l.TotalChildDuration()
+		reportDuration := l.ReportedDuration(excludeChildren)
+		var key string
+		if useCache {
+			key = l.cachedPath(separator)
+		} else {
+			key = path + l.Name
+			childPrefix = key + separator
 		}
-		key := fmt.Sprintf("%s%s", path, l.Name)
-		if l.EntryCount > 0 {
-			m[key] = float64(reportDuration.Nanoseconds()) / divisor
+		if l.Entries() > 0 {
+			emit(key, float64(reportDuration.Nanoseconds())/divisor)
 		}
-		childPrefix = path + l.Name + separator
 	}
-	for _, c := range l.Children {
-		c.dumpToMap(m, separator, childPrefix, divisor, excludeChildren)
+	for _, c := range l.snapshotChildren() {
+		c.dumpEntries(emit, separator, childPrefix, divisor, excludeChildren, useCache)
 	}
 }
 
-func (l *Location) formatDetails(prefix string) string {
-	if l.Details == nil || len(l.Details) == 0 {
+// formatDetails formats the details of the location for the report. The details are copied under the
+// lock so that they can be added to while the report is being generated, and so that the formatters
+// are called without holding it.
+func (l *Location) formatDetails(prefix string, options *ReportOptions) string {
+	l.mu.RLock()
+	if len(l.Details) == 0 {
+		l.mu.RUnlock()
 		return ""
 	}
-	var keys []string
-	for k := range l.Details {
-		keys = append(keys, k)
+	keys := l.detailKeys(options.DetailsInOrder)
+	values := make([]anything, len(keys))
+	for i, k := range keys {
+		values[i] = l.Details[k]
 	}
-	sort.Strings(keys)
+	l.mu.RUnlock()
+
 	anyNewlines := false
 	formattedDetails := map[string]string{}
-	for _, k := range keys {
-		o := l.Details[k]
-		s := fmt.Sprintf("%+v", o)
+	for i, k := range keys {
+		s := options.formatDetail(k, values[i])
 		if strings.Contains(s, "\n") {
 			anyNewlines = true
 		}
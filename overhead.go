@@ -0,0 +1,51 @@
+package timing
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// overheadRounds is the number of independent measurements that are taken when calibrating.
+	overheadRounds = 10
+
+	// overheadIterations is the number of Start/Complete pairs that are timed in each round.
+	overheadIterations = 1000
+)
+
+var (
+	overheadOnce       sync.Once
+	calibratedOverhead time.Duration
+)
+
+// CalibrateOverhead measures how much time a Start/Complete pair takes for a region that does no
+// work at all. The measurement is taken only once and the result is cached, so this is cheap
+// to call repeatedly. This is used by ReportOptions.SubtractOverhead, but it can be called ahead
+// of time to avoid paying for the calibration during the first report.
+func CalibrateOverhead() time.Duration {
+	overheadOnce.Do(func() {
+		calibratedOverhead = measureOverhead()
+	})
+	return calibratedOverhead
+}
+
+// measureOverhead times a number of empty regions and returns the lowest per-call average that was
+// seen across several rounds. The lowest is used since anything higher is caused by interference from
+// the scheduler, garbage collector, etc. and not by the timer itself. The regions are timed with
+// time.Now rather than the replaceable clock, so that the cached result isn't thrown off by a fake
+// clock that happens to be installed the first time this runs.
+func measureOverhead() time.Duration {
+	best := time.Duration(-1)
+	for r := 0; r < overheadRounds; r++ {
+		l := &Location{}
+		begin := time.Now()
+		for i := 0; i < overheadIterations; i++ {
+			l.Start()()
+		}
+		perCall := time.Since(begin) / overheadIterations
+		if best < 0 || perCall < best {
+			best = perCall
+		}
+	}
+	return best
+}
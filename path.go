@@ -0,0 +1,147 @@
+package timing
+
+import "strings"
+
+// defaultSeparator is the separator that is used between levels when none is specified.
+const defaultSeparator = " > "
+
+// pathCache holds the full path of a location along with the separator that was used to build it.
+type pathCache struct {
+	separator string
+	path      string
+}
+
+// CachedPath returns the full path of this location from the root of its tree, with the levels
+// separated by " > ". The path is computed once and cached, so repeatedly asking for it is cheap.
+// The cache is invalidated when the location, or any of its ancestors, is renamed.
+func (l *Location) CachedPath() string {
+	return l.cachedPath(defaultSeparator)
+}
+
+// cachedPath returns the full path of this location using the given separator. Only the most
+// recently used separator is cached.
+func (l *Location) cachedPath(separator string) string {
+	if pc, ok := l.pathCache.Load().(pathCache); ok && pc.path != "" && pc.separator == separator {
+		return pc.path
+	}
+	var path string
+	if l.parent == nil || l.parent.Name == "" {
+		path = l.Name
+	} else {
+		path = l.parent.cachedPath(separator) + separator + l.Name
+	}
+	l.pathCache.Store(pathCache{separator: separator, path: path})
+	return path
+}
+
+// invalidatePath clears the cached path of this location and all of its descendants.
+func (l *Location) invalidatePath() {
+	l.pathCache.Store(pathCache{})
+
+	l.mu.RLock()
+	children := make([]*Location, 0, len(l.Children))
+	for _, c := range l.Children {
+		children = append(children, c)
+	}
+	l.mu.RUnlock()
+
+	for _, c := range children {
+		c.invalidatePath()
+	}
+}
+
+// Rename changes the name of this location. If the location has a parent, the parent's children
+// are updated while keeping the call order. This panics if the parent already has a different
+// child with the new name. The name is written under the locks of the parent and the location, but
+// since the reports and most other readers read it without a lock, this must not be called while
+// the tree is being timed or reported on by other Goroutines.
+func (l *Location) Rename(name string) {
+	if l.isDisabled() {
+		return
+	}
+	if name == l.Name {
+		return
+	}
+	if p := l.parent; p != nil {
+		if name == "" {
+			panic("non-root timings must be named")
+		}
+		p.mu.Lock()
+		if _, exists := p.Children[name]; exists {
+			p.mu.Unlock()
+			panic("a timing named " + name + " already exists")
+		}
+		delete(p.Children, l.Name)
+		p.Children[name] = l
+		for i, n := range p.CallOrder {
+			if n == l.Name {
+				p.CallOrder[i] = name
+			}
+		}
+		l.setName(name)
+		p.mu.Unlock()
+	} else {
+		l.setName(name)
+	}
+	l.invalidatePath()
+}
+
+// setName changes the name of this location under its lock.
+func (l *Location) setName(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.Name = name
+}
+
+// Get returns the descendant of this location that is reached by following the children with the
+// names, in order, or nil if there isn't one. With no names, this location itself is returned.
+func (l *Location) Get(names ...string) *Location {
+	for _, name := range names {
+		l.mu.RLock()
+		c := l.Children[name]
+		l.mu.RUnlock()
+		if c == nil {
+			return nil
+		}
+		l = c
+	}
+	return l
+}
+
+// GetByPath returns the location with the path, as it is shown in the reports of this location, or
+// nil if there isn't one. The path starts with the name of this location, unless it is an unnamed
+// root, and the levels are separated by the separator, or " > " if it is empty. Async locations may
+// be given with the brackets that the reports show around their names. For instance,
+// root.GetByPath("root > [child]", "") is the same as root.Get("child") if the child is Async.
+func (l *Location) GetByPath(path, separator string) *Location {
+	if separator == "" {
+		separator = defaultSeparator
+	}
+	names := strings.Split(path, separator)
+	if l.Name != "" {
+		if !l.matchesReportedName(names[0]) {
+			return nil
+		}
+		names = names[1:]
+	}
+	for _, name := range names {
+		l.mu.RLock()
+		c := l.Children[name]
+		if c == nil && strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+			c = l.Children[name[1:len(name)-1]]
+		}
+		l.mu.RUnlock()
+		if c == nil || !c.matchesReportedName(name) {
+			return nil
+		}
+		l = c
+	}
+	return l
+}
+
+// matchesReportedName returns true if the name is the name of this location, either as it is or as
+// the reports show it.
+func (l *Location) matchesReportedName(name string) bool {
+	return name == l.Name || name == l.effectiveName()
+}
@@ -0,0 +1,82 @@
+package timing
+
+import (
+	"context"
+	"sync"
+)
+
+// contextPool holds the Context objects that have been released to be reused. See Context.Release.
+var contextPool = sync.Pool{
+	New: func() interface{} {
+		return &Context{}
+	},
+}
+
+// locationPool holds the Location objects that have been released to be reused. See Location.Release.
+var locationPool = sync.Pool{
+	New: func() interface{} {
+		return &Location{}
+	},
+}
+
+// newContext returns a timing context for the location on top of prev, reusing a released one if
+// there is any.
+func newContext(prev context.Context, l *Location) *Context {
+	c := contextPool.Get().(*Context)
+	c.prevCtx = prev
+	c.Location = l
+	return c
+}
+
+// newLocation returns an empty location with the name, reusing a released one if there is any.
+func newLocation(name string) *Location {
+	l := locationPool.Get().(*Location)
+	l.Name = name
+	return l
+}
+
+// Release returns this timing context to a pool so that a later Start can reuse it instead of
+// allocating a new one. This is for hot paths that time many short-lived calls, where the timing
+// context is thrown away as soon as the call is completed:
+//
+//	c, complete := timing.Start(ctx, "parse")
+//	parse(c)
+//	complete()
+//	c.Release()
+//
+// Only the timing context itself is released, not its location, so the timings are unaffected. The
+// timing context must not be used in any way after it is released, including as the parent context
+// of anything that is still running, since it will be handed out again.
+func (c *Context) Release() {
+	if c.isDisabled() {
+		// The placeholder may be shared by nested timings.
+		return
+	}
+	c.prevCtx = nil
+	c.Location = nil
+	contextPool.Put(c)
+}
+
+// Release returns every location of this tree to a pool so that they can be reused by later timings
+// instead of allocating new ones. This is for workloads that create and discard many short-lived
+// trees, such as one per request with StartRoot, once they have been reported on. Only a root can
+// be released, and nothing in the tree, including the timing contexts for it, may be used after it
+// is released. This panics if the location is not a root.
+func (l *Location) Release() {
+	if l.isDisabled() {
+		return
+	}
+	if l.parent != nil {
+		panic("only roots can be released")
+	}
+	l.release()
+}
+
+// release clears this location and its descendants and returns them to the pool.
+func (l *Location) release() {
+	for _, c := range l.snapshotChildren() {
+		c.release()
+	}
+	*l = Location{}
+	locationPool.Put(l)
+}
@@ -0,0 +1,104 @@
+package timing
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ContextRelease(t *testing.T) {
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	for i := 0; i < 3; i++ {
+		c, complete := Start(rootCtx, "child")
+		complete()
+		c.Release()
+		assert.Nil(t, c.Location)
+		assert.Nil(t, c.prevCtx)
+	}
+	rootComplete()
+
+	assert.Equal(t, uint64(3), rootCtx.Children["child"].Exits())
+	assert.Equal(t, []string{"child"}, rootCtx.CallOrder)
+
+	// The placeholder that is used while timing is disabled is shared, so it's never released.
+	SetEnabled(false)
+	defer SetEnabled(true)
+	c, complete := Start(rootCtx, "disabled")
+	complete()
+	c.Release()
+	assert.Same(t, disabledLocation, c.Location)
+}
+
+func Test_LocationRelease(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := StartRoot(context.Background(), "root")
+	childCtx, childComplete := Start(rootCtx, "child")
+	childCtx.AddDetails("rows", 5)
+	childComplete()
+	rootComplete()
+
+	assert.PanicsWithValue(t, "only roots can be released", func() {
+		childCtx.Location.Release()
+	})
+
+	child := childCtx.Location
+	rootCtx.Location.Release()
+	assert.Equal(t, "", child.Name)
+	assert.Nil(t, child.parent)
+	assert.Nil(t, child.Details)
+	assert.Equal(t, uint64(0), child.Entries())
+
+	// New trees start out empty, whether or not they reuse released locations.
+	for i := 0; i < 3; i++ {
+		c, complete := StartRoot(context.Background(), "root "+strconv.Itoa(i))
+		_, childComplete := Start(c, "child")
+		childComplete()
+		complete()
+		assert.Equal(t, "root "+strconv.Itoa(i)+" - 0s\nroot "+strconv.Itoa(i)+" > child - 0s", c.String())
+		c.Location.Release()
+	}
+}
+
+func Benchmark_StartRelease(b *testing.B) {
+	ctx, complete := Start(context.Background(), "root")
+	defer complete()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, complete := Start(ctx, "query")
+		complete()
+		c.Release()
+	}
+}
+
+func Benchmark_StartRootRelease(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c, complete := StartRoot(context.Background(), "request")
+		for j := 0; j < 5; j++ {
+			child, childComplete := Start(c, "step")
+			childComplete()
+			child.Release()
+		}
+		complete()
+		c.Location.Release()
+		c.Release()
+	}
+}
+
+func Benchmark_StartRootNoRelease(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c, complete := StartRoot(context.Background(), "request")
+		for j := 0; j < 5; j++ {
+			_, childComplete := Start(c, "step")
+			childComplete()
+		}
+		complete()
+	}
+}
@@ -0,0 +1,103 @@
+package timing
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Registry keeps track of the roots of timing trees that are in progress, such as one for each
+// request that a server is handling, so that they can be inspected or reported on while they run.
+// The number of roots that the registry holds is capped; registering a root beyond the capacity
+// evicts the oldest root so that roots that are never unregistered can't leak memory.
+//
+// A Registry is safe for concurrent use.
+type Registry struct {
+	mu sync.Mutex
+
+	capacity int
+	order    *list.List
+	index    map[*Location]*list.Element
+	peak     int
+	evicted  uint64
+}
+
+// RegistryStats has the statistics of a Registry.
+type RegistryStats struct {
+	// Current is the number of roots that are currently registered.
+	Current int
+
+	// Peak is the largest number of roots that have been registered at the same time.
+	Peak int
+
+	// Evicted is the number of roots that have been evicted because the registry was full.
+	Evicted uint64
+}
+
+// NewRegistry creates a Registry that holds at most capacity roots.
+func NewRegistry(capacity int) *Registry {
+	if capacity <= 0 {
+		panic("registry capacity must be positive")
+	}
+	return &Registry{
+		capacity: capacity,
+		order:    list.New(),
+		index:    map[*Location]*list.Element{},
+	}
+}
+
+// Register adds a root to the registry. If the registry is full, the oldest root is evicted to make
+// room for it. Registering a root that is already registered has no effect.
+func (r *Registry) Register(root *Location) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.index[root]; ok {
+		return
+	}
+	for r.order.Len() >= r.capacity {
+		oldest := r.order.Front()
+		r.order.Remove(oldest)
+		delete(r.index, oldest.Value.(*Location))
+		r.evicted++
+	}
+	r.index[root] = r.order.PushBack(root)
+	if r.order.Len() > r.peak {
+		r.peak = r.order.Len()
+	}
+}
+
+// Unregister removes a root from the registry. Unregistering a root that isn't registered, for
+// instance because it has been evicted, has no effect.
+func (r *Registry) Unregister(root *Location) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.index[root]; ok {
+		r.order.Remove(e)
+		delete(r.index, root)
+	}
+}
+
+// Roots returns the registered roots, oldest first.
+func (r *Registry) Roots() []*Location {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	roots := make([]*Location, 0, r.order.Len())
+	for e := r.order.Front(); e != nil; e = e.Next() {
+		roots = append(roots, e.Value.(*Location))
+	}
+	return roots
+}
+
+// Stats returns the current statistics of the registry.
+func (r *Registry) Stats() RegistryStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return RegistryStats{
+		Current: r.order.Len(),
+		Peak:    r.peak,
+		Evicted: r.evicted,
+	}
+}
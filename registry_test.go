@@ -0,0 +1,67 @@
+package timing
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func Test_RegistryEviction(t *testing.T) {
+	r := NewRegistry(2)
+
+	var roots []*Location
+	for i := 0; i < 3; i++ {
+		c, _ := StartRoot(context.Background(), "root "+strconv.Itoa(i))
+		roots = append(roots, c.Location)
+	}
+
+	r.Register(roots[0])
+	r.Register(roots[1])
+	r.Register(roots[1])
+	assert.Equal(t, []*Location{roots[0], roots[1]}, r.Roots())
+
+	r.Register(roots[2])
+	assert.Equal(t, []*Location{roots[1], roots[2]}, r.Roots())
+	assert.Equal(t, RegistryStats{Current: 2, Peak: 2, Evicted: 1}, r.Stats())
+
+	r.Unregister(roots[0])
+	r.Unregister(roots[1])
+	assert.Equal(t, []*Location{roots[2]}, r.Roots())
+	assert.Equal(t, RegistryStats{Current: 1, Peak: 2, Evicted: 1}, r.Stats())
+
+	assert.Panics(t, func() {
+		NewRegistry(0)
+	})
+}
+
+func Test_RegistryConcurrent(t *testing.T) {
+	const capacity = 10
+	r := NewRegistry(capacity)
+
+	wg := sync.WaitGroup{}
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				c, complete := StartRoot(context.Background(), "request")
+				r.Register(c.Location)
+				assert.LessOrEqual(t, r.Stats().Current, capacity)
+				complete()
+				if i%2 == 0 {
+					r.Unregister(c.Location)
+				}
+				r.Roots()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	stats := r.Stats()
+	assert.LessOrEqual(t, stats.Current, capacity)
+	assert.Equal(t, capacity, stats.Peak)
+	assert.Greater(t, stats.Evicted, uint64(0))
+	assert.Len(t, r.Roots(), stats.Current)
+}
@@ -0,0 +1,103 @@
+package timing
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// minSamplesForInterval is the fewest samples a confidence interval can be computed from.
+const minSamplesForInterval = 2
+
+// RecordSamples turns on the recording of the durations of the individual calls of this location.
+// At most max of the most recent durations are kept. Recording samples costs a lock and some memory
+// for every call, so it is off by default. Passing a max of zero turns recording off and discards
+// any recorded samples.
+func (l *Location) RecordSamples(max int) {
+	if l.isDisabled() {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if max <= 0 {
+		l.samples = nil
+		l.sampleNext = 0
+		atomic.StoreInt32(&l.sampleLimit, 0)
+		return
+	}
+	l.samples = make([]time.Duration, 0, max)
+	l.sampleNext = 0
+	atomic.StoreInt32(&l.sampleLimit, int32(max))
+}
+
+// Samples returns the recorded durations of the individual calls, oldest first. This is empty unless
+// recording was turned on with RecordSamples.
+func (l *Location) Samples() []time.Duration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	result := make([]time.Duration, 0, len(l.samples))
+	if len(l.samples) == cap(l.samples) {
+		result = append(result, l.samples[l.sampleNext:]...)
+		return append(result, l.samples[:l.sampleNext]...)
+	}
+	return append(result, l.samples...)
+}
+
+// addSample records the duration of a call. Once the buffer is full, the oldest sample is replaced.
+func (l *Location) addSample(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if cap(l.samples) == 0 {
+		return
+	}
+	if len(l.samples) < cap(l.samples) {
+		l.samples = append(l.samples, d)
+		return
+	}
+	l.samples[l.sampleNext] = d
+	l.sampleNext = (l.sampleNext + 1) % len(l.samples)
+}
+
+// ConfidenceInterval computes a confidence interval for the mean duration of a call from the
+// recorded samples, using the normal approximation. The level is the confidence level, e.g. 0.95
+// for a 95% confidence interval. If fewer than two samples have been recorded, or the level is not
+// between 0 and 1, both bounds are zero.
+func (l *Location) ConfidenceInterval(level float64) (low, high time.Duration) {
+	samples := l.Samples()
+	n := len(samples)
+	if n < minSamplesForInterval || level <= 0 || level >= 1 {
+		return 0, 0
+	}
+
+	mean := 0.0
+	for _, s := range samples {
+		mean += float64(s)
+	}
+	mean /= float64(n)
+
+	variance := 0.0
+	for _, s := range samples {
+		diff := float64(s) - mean
+		variance += diff * diff
+	}
+	variance /= float64(n - 1)
+
+	z := math.Sqrt2 * math.Erfinv(level)
+	margin := z * math.Sqrt(variance/float64(n))
+	return time.Duration(math.Round(mean - margin)), time.Duration(math.Round(mean + margin))
+}
+
+// formatConfidenceInterval formats the confidence interval of the location, or returns an empty
+// string if there aren't enough samples to compute one.
+func (l *Location) formatConfidenceInterval(options *ReportOptions) string {
+	low, high := l.ConfidenceInterval(options.ConfidenceLevel)
+	if low == 0 && high == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%g%% CI: %s–%s)", options.ConfidenceLevel*100,
+		options.formatDuration(low), options.formatDuration(high))
+}
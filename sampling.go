@@ -0,0 +1,39 @@
+package timing
+
+import "sync/atomic"
+
+// SetSampling makes this location, and all of its descendants, only time one in every oneIn calls
+// instead of all of them. This is for code that is called so often that timing every call is too
+// expensive. The calls that are not sampled are not timed at all; their Complete function does
+// nothing. A oneIn of 1 or less turns sampling off.
+//
+// To keep the totals meaningful, each sampled call is counted as oneIn calls: EntryCount and ExitCount
+// are estimates of the number of calls that were made, not the number that were timed, and
+// TotalDuration is scaled up to match. The per-call averages in the reports are therefore unaffected,
+// while MinDuration and MaxDuration are those of the calls that were actually timed. Locations that
+// are created under this one later inherit the setting.
+func (l *Location) SetSampling(oneIn int) {
+	if l.isDisabled() {
+		return
+	}
+	if oneIn < 1 {
+		oneIn = 1
+	}
+	atomic.StoreInt32(&l.samplingOneIn, int32(oneIn))
+	for _, c := range l.snapshotChildren() {
+		c.SetSampling(oneIn)
+	}
+}
+
+// sampleWeight decides whether the call that is being started is to be timed. It returns the number
+// of calls that the timed call stands for, or zero if it is not to be timed.
+func (l *Location) sampleWeight() uint64 {
+	oneIn := atomic.LoadInt32(&l.samplingOneIn)
+	if oneIn <= 1 {
+		return 1
+	}
+	if (atomic.AddUint32(&l.samplingCounter, 1)-1)%uint32(oneIn) != 0 {
+		return 0
+	}
+	return uint64(oneIn)
+}
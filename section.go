@@ -0,0 +1,72 @@
+package timing
+
+import (
+	"strings"
+	"time"
+)
+
+// Section begins timing a named section of the work that is being timed by this context, such as
+// the part that runs while holding a lock. Unlike a child timing context, a section is not a
+// separate location in the tree; its duration is recorded on this location and shown as a
+// breakdown next to it in the reports. Timing the same section repeatedly accumulates its duration.
+// It returns a Complete function that is to be called when the section is done.
+func (c *Context) Section(name string) Complete {
+	return c.Location.startSection(name)
+}
+
+// startSection begins timing the named section of this location.
+func (l *Location) startSection(name string) Complete {
+	if name == "" {
+		panic("sections must be named")
+	}
+	if l.isDisabled() {
+		return noopComplete
+	}
+	ended := false
+	startTime := now()
+	return func() {
+		d := now().Sub(startTime)
+		if ended {
+			panic("section already completed")
+		}
+		ended = true
+		l.addSectionDuration(name, d)
+	}
+}
+
+// addSectionDuration adds to the total duration of the named section.
+func (l *Location) addSectionDuration(name string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.Sections == nil {
+		l.Sections = map[string]time.Duration{}
+	}
+	if _, ok := l.Sections[name]; !ok {
+		l.sectionOrder = append(l.sectionOrder, name)
+	}
+	l.Sections[name] += d
+}
+
+// formatSections formats the section breakdown of this location in the order the sections were
+// first completed. This is empty if there are no sections.
+func (l *Location) formatSections(options *ReportOptions) string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if len(l.sectionOrder) == 0 {
+		return ""
+	}
+	b := strings.Builder{}
+	b.WriteString(" (sections: ")
+	for i, name := range l.sectionOrder {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		b.WriteString(" ")
+		b.WriteString(options.formatDuration(l.Sections[name]))
+	}
+	b.WriteString(")")
+	return b.String()
+}
@@ -0,0 +1,194 @@
+package timing
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Sink receives every location of a timing tree during a call to Emit. This allows multiple outputs
+// to be generated from a single traversal of the tree.
+type Sink interface {
+	// VisitNode is called for every named location, parents before their children and siblings in
+	// call order. The path contains the names of the location's ancestors, starting from the location
+	// Emit was called on, followed by the location's own name.
+	VisitNode(path []string, l *Location)
+
+	// Done is called once all the locations have been visited.
+	Done()
+}
+
+// rootedSink is implemented by the sinks that need to know the location that Emit was called on.
+type rootedSink interface {
+	setRoot(root *Location)
+}
+
+// Emit traverses the tree once and passes each location to every one of the sinks. Unnamed roots
+// are not passed to the sinks, but their children are.
+func (l *Location) Emit(sinks ...Sink) {
+	for _, s := range sinks {
+		if r, ok := s.(rootedSink); ok {
+			r.setRoot(l)
+		}
+	}
+	l.emit(nil, sinks)
+	for _, s := range sinks {
+		s.Done()
+	}
+}
+
+// emit recursively visits the location and its children.
+func (l *Location) emit(path []string, sinks []Sink) {
+	if l.Name != "" {
+		path = append(path[:len(path):len(path)], l.Name)
+		for _, s := range sinks {
+			s.VisitNode(path, l)
+		}
+	}
+	for _, c := range l.snapshotChildren() {
+		c.emit(path, sinks)
+	}
+}
+
+// TextSink builds a text report like Report does. Since the sink sees each location only once, in
+// call order, the options that change which locations are reported or how they are ordered and
+// laid out are ignored: SortBy, ChildLess, MaxDepth, MinDuration, HideUnentered, SeparateAsync and
+// TreeStyle. With any of those, use Report instead.
+type TextSink struct {
+	options ReportOptions
+	b       strings.Builder
+	names   []string
+}
+
+// NewTextSink creates a TextSink that formats the report using the options.
+func NewTextSink(options ReportOptions) *TextSink {
+	options.applyDefaults()
+	return &TextSink{
+		options: options,
+	}
+}
+
+// VisitNode adds the location to the report.
+func (s *TextSink) VisitNode(path []string, l *Location) {
+	s.names = append(s.names[:len(path)-1], l.effectiveName())
+
+	var linePrefix string
+	if s.options.Compact {
+		linePrefix = strings.Repeat(s.options.Separator, len(path)-1)
+	} else {
+		linePrefix = strings.Join(s.names[:len(path)-1], s.options.Separator)
+		if linePrefix != "" {
+			linePrefix += s.options.Separator
+		}
+	}
+
+	if l.Entries() > 0 || len(l.snapshotChildren()) == 0 {
+		if s.b.Len() > 0 {
+			s.b.WriteString("\n")
+		}
+		s.b.WriteString(s.options.Prefix)
+		s.b.WriteString(linePrefix)
+		s.b.WriteString(l.effectiveName())
+		l.writeTimings(&s.b, &s.options)
+	}
+
+	if s.options.Compact {
+		s.b.WriteString(l.formatDetails(s.options.Prefix+linePrefix+s.options.Separator, &s.options))
+	} else {
+		s.b.WriteString(l.formatDetails(s.options.Prefix, &s.options))
+	}
+}
+
+// setRoot makes the shares of the total, such as those of ShowPercentages and Color, relative to the
+// location that Emit was called on.
+func (s *TextSink) setRoot(root *Location) {
+	s.options.root = root
+}
+
+// Done is a no-op for the TextSink.
+func (s *TextSink) Done() {}
+
+// String returns the report.
+func (s *TextSink) String() string {
+	return s.b.String()
+}
+
+// MapSink builds the same map as ReportMap does.
+type MapSink struct {
+	// Map has the reported durations keyed by the path of each location.
+	Map map[string]float64
+
+	separator       string
+	divisor         float64
+	excludeChildren bool
+}
+
+// NewMapSink creates a MapSink. The parameters are the same as for ReportMap.
+func NewMapSink(separator string, divisor float64, excludeChildren bool) *MapSink {
+	return &MapSink{
+		Map:             map[string]float64{},
+		separator:       separator,
+		divisor:         divisor,
+		excludeChildren: excludeChildren,
+	}
+}
+
+// VisitNode adds the location's duration to the map if it has been entered.
+func (s *MapSink) VisitNode(path []string, l *Location) {
+	if l.Entries() == 0 {
+		return
+	}
+	d := l.reportedDuration(&ReportOptions{ExcludeChildren: s.excludeChildren})
+	s.Map[strings.Join(path, s.separator)] = float64(d.Nanoseconds()) / s.divisor
+}
+
+// Done is a no-op for the MapSink.
+func (s *MapSink) Done() {}
+
+// JSONSink builds a flat JSON array with one object for each location.
+type JSONSink struct {
+	entries []jsonSinkEntry
+	data    []byte
+	err     error
+}
+
+// jsonSinkEntry is the JSON representation of a single location in the JSONSink's output.
+type jsonSinkEntry struct {
+	Path          []string            `json:"path"`
+	EntryCount    uint64              `json:"entry-count,omitempty"`
+	ExitCount     uint64              `json:"exit-count,omitempty"`
+	TotalDuration time.Duration       `json:"total-duration,omitempty"`
+	Async         bool                `json:"async,omitempty"`
+	Details       map[string]anything `json:"details,omitempty"`
+}
+
+// NewJSONSink creates an empty JSONSink.
+func NewJSONSink() *JSONSink {
+	return &JSONSink{}
+}
+
+// VisitNode adds the location to the array.
+func (s *JSONSink) VisitNode(path []string, l *Location) {
+	c := l.copyNode()
+	s.entries = append(s.entries, jsonSinkEntry{
+		Path:          append([]string(nil), path...),
+		EntryCount:    c.EntryCount,
+		ExitCount:     c.ExitCount,
+		TotalDuration: c.TotalDuration,
+		Async:         c.Async,
+		Details:       c.Details,
+	})
+}
+
+// Done marshals the collected locations.
+func (s *JSONSink) Done() {
+	if s.entries == nil {
+		s.entries = []jsonSinkEntry{}
+	}
+	s.data, s.err = json.Marshal(s.entries)
+}
+
+// JSON returns the marshaled array along with any error that happened while marshaling it.
+func (s *JSONSink) JSON() ([]byte, error) {
+	return s.data, s.err
+}
@@ -0,0 +1,95 @@
+package timing
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	paths []string
+	done  bool
+}
+
+func (s *recordingSink) VisitNode(path []string, l *Location) {
+	s.paths = append(s.paths, strings.Join(path, "/"))
+}
+
+func (s *recordingSink) Done() {
+	s.done = true
+}
+
+func buildSinkTree() *Context {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	asyncCtx, asyncComplete := StartAsync(rootCtx, "async")
+	taskCtx, taskComplete := Start(asyncCtx, "task")
+	taskComplete()
+	taskCtx.AddDetails("items", 3)
+	asyncComplete()
+	childCtx, childComplete := Start(rootCtx, "child")
+	childComplete()
+	childCtx.AddDetails("multi", "line 1\nline 2")
+	rootComplete()
+
+	rootCtx.TotalDuration = 100 * time.Millisecond
+	asyncCtx.TotalDuration = 50 * time.Millisecond
+	taskCtx.TotalDuration = 30 * time.Millisecond
+	childCtx.TotalDuration = 20 * time.Millisecond
+	return rootCtx
+}
+
+func Test_EmitMultipleSinks(t *testing.T) {
+	rootCtx := buildSinkTree()
+
+	s1 := &recordingSink{}
+	s2 := &recordingSink{}
+	rootCtx.Emit(s1, s2)
+
+	expected := []string{"root", "root/async", "root/async/task", "root/child"}
+	assert.Equal(t, expected, s1.paths)
+	assert.Equal(t, expected, s2.paths)
+	assert.True(t, s1.done)
+	assert.True(t, s2.done)
+
+	unnamed := Root(context.Background())
+	_, complete := Start(unnamed, "child")
+	complete()
+	s3 := &recordingSink{}
+	unnamed.Emit(s3)
+	assert.Equal(t, []string{"child"}, s3.paths)
+}
+
+func Test_EmitBuiltInSinks(t *testing.T) {
+	rootCtx := buildSinkTree()
+
+	for _, options := range []ReportOptions{
+		{},
+		{ExcludeChildren: true},
+		{Compact: true, Prefix: "* "},
+		{Separator: "."},
+		{ShowPercentages: true},
+		{Color: true},
+	} {
+		text := NewTextSink(options)
+		m := NewMapSink(" > ", 1000000, options.ExcludeChildren)
+		j := NewJSONSink()
+		rootCtx.Emit(text, m, j)
+
+		assert.Equal(t, rootCtx.Report(options), text.String())
+		assert.Equal(t, rootCtx.ReportMap(" > ", 1000000, options.ExcludeChildren), m.Map)
+
+		data, err := j.JSON()
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), `{"path":["root","async","task"],"entry-count":1,"exit-count":1,"total-duration":30000000,"details":{"items":3}}`)
+	}
+
+	j := NewJSONSink()
+	Root(context.Background()).Emit(j)
+	data, err := j.JSON()
+	assert.NoError(t, err)
+	assert.Equal(t, "[]", string(data))
+}
@@ -0,0 +1,36 @@
+//go:build go1.21
+
+package timing
+
+import (
+	"log/slog"
+)
+
+// LogValue implements slog.LogValuer so that a Location, or a Context, can be logged directly as a
+// structured group. The group has the "duration", "entries", and "exits" of the location, "async"
+// if it is Async, and the "note" if it has one, followed by its details as attributes with their
+// keys, in the order they were added. Each child is a nested group that is named after it, in the
+// order they were called.
+//
+//	slog.Info("request complete", "timing", ctx)
+func (l *Location) LogValue() slog.Value {
+	n := l.copyNode()
+	attrs := []slog.Attr{
+		slog.Duration("duration", n.TotalDuration),
+		slog.Uint64("entries", n.EntryCount),
+		slog.Uint64("exits", n.ExitCount),
+	}
+	if n.Async {
+		attrs = append(attrs, slog.Bool("async", true))
+	}
+	if n.NoteText != "" {
+		attrs = append(attrs, slog.String("note", n.NoteText))
+	}
+	for _, k := range n.detailKeys(true) {
+		attrs = append(attrs, slog.Any(k, n.Details[k]))
+	}
+	for _, child := range l.snapshotChildren() {
+		attrs = append(attrs, slog.Attr{Key: child.Name, Value: child.LogValue()})
+	}
+	return slog.GroupValue(attrs...)
+}
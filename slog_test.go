@@ -0,0 +1,46 @@
+//go:build go1.21
+
+package timing
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LogValue(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, complete := Start(context.Background(), "root")
+	childCtx, childComplete := Start(rootCtx, "child")
+	childCtx.AddDetails("rows", 12)
+	childCtx.AddDetails("table", "users")
+	childComplete()
+	asyncCtx, asyncComplete := StartAsync(rootCtx, "async")
+	asyncComplete()
+	complete()
+	rootCtx.TotalDuration = 100 * time.Millisecond
+	childCtx.TotalDuration = 40 * time.Millisecond
+	asyncCtx.TotalDuration = 10 * time.Millisecond
+
+	buf := bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	logger.Info("done", "timing", rootCtx)
+
+	expected := `{"level":"INFO","msg":"done","timing":{"duration":100000000,"entries":1,"exits":1,` +
+		`"child":{"duration":40000000,"entries":1,"exits":1,"rows":12,"table":"users"},` +
+		`"async":{"duration":10000000,"entries":1,"exits":1,"async":true}}}` + "\n"
+	assert.Equal(t, expected, buf.String())
+}
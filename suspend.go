@@ -0,0 +1,48 @@
+package timing
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SuspectedSuspendDetail is the detail that is recorded on a location when one of its calls took
+// longer than is plausible. The value of the detail is the measured duration of that call.
+const SuspectedSuspendDetail = "suspected-suspend"
+
+var (
+	// maxPlausibleSpan is the longest a single call can plausibly take, in nanoseconds. Zero
+	// disables the detection.
+	maxPlausibleSpan int64
+
+	// clampImplausibleSpans is non-zero if implausible calls are clamped to maxPlausibleSpan.
+	clampImplausibleSpans int32
+)
+
+// DetectSuspend enables the detection of calls that took implausibly long. The monotonic clock that
+// is used for timing does not exclude the time that the computer was suspended, so a call that is
+// in progress while a laptop sleeps can appear to take hours. Any call that takes longer than max
+// is flagged by recording the SuspectedSuspendDetail detail on its location. If clamp is set, the
+// duration that is recorded for the call is also reduced to max so that the outlier doesn't
+// distort the totals. Passing a max of zero disables the detection, which is the default.
+func DetectSuspend(max time.Duration, clamp bool) {
+	var c int32
+	if clamp {
+		c = 1
+	}
+	atomic.StoreInt32(&clampImplausibleSpans, c)
+	atomic.StoreInt64(&maxPlausibleSpan, int64(max))
+}
+
+// checkSuspend flags the call on this location if its duration is implausibly long, and returns
+// the duration that is to be recorded for it.
+func (l *Location) checkSuspend(d time.Duration) time.Duration {
+	max := time.Duration(atomic.LoadInt64(&maxPlausibleSpan))
+	if max <= 0 || d <= max {
+		return d
+	}
+	l.AddDetails(SuspectedSuspendDetail, d)
+	if atomic.LoadInt32(&clampImplausibleSpans) != 0 {
+		return max
+	}
+	return d
+}
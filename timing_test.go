@@ -1,13 +1,19 @@
 package timing
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 func Test_TrivialRoot(t *testing.T) {
@@ -15,8 +21,8 @@ func Test_TrivialRoot(t *testing.T) {
 
 	tCtx := Root(ctx)
 
-	assert.Equal(t, uint32(0), tCtx.EntryCount)
-	assert.Equal(t, uint32(0), tCtx.ExitCount)
+	assert.Equal(t, uint64(0), tCtx.EntryCount)
+	assert.Equal(t, uint64(0), tCtx.ExitCount)
 
 	assert.Equal(t, "", tCtx.String())
 
@@ -38,8 +44,8 @@ func Test_NonTrivialRoot(t *testing.T) {
 	time.Sleep(time.Millisecond)
 	complete()
 
-	assert.Equal(t, uint32(1), tCtx.EntryCount)
-	assert.Equal(t, uint32(1), tCtx.ExitCount)
+	assert.Equal(t, uint64(1), tCtx.EntryCount)
+	assert.Equal(t, uint64(1), tCtx.ExitCount)
 	assert.Greater(t, tCtx.TotalDuration, time.Duration(0))
 
 	tCtx.TotalDuration = 100 * time.Millisecond
@@ -48,6 +54,10 @@ func Test_NonTrivialRoot(t *testing.T) {
 }
 
 func Test_Nesting(t *testing.T) {
+	// The durations are set by hand, so stop the clock to keep the individual calls from being tracked.
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
 	ctx := context.Background()
 
 	rootCtx, rootComplete := Start(ctx, "root")
@@ -95,7 +105,7 @@ func Test_Nesting(t *testing.T) {
 
 	js, err := json.Marshal(rootCtx)
 	assert.NoError(t, err)
-	expected := `{"name":"root","children":{"child 1":{"name":"child 1","entry-count":1,"exit-count":1,"total-duration":100000000},"child 2":{"name":"child 2","entry-count":1,"exit-count":1,"total-duration":100000000}},"entry-count":1,"exit-count":1,"total-duration":210000000}`
+	expected := `{"name":"root","children":{"child 1":{"name":"child 1","entry-count":1,"exit-count":1,"total-duration":100000000,"first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00Z"},"child 2":{"name":"child 2","entry-count":1,"exit-count":1,"total-duration":100000000,"first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00Z"}},"entry-count":1,"exit-count":1,"total-duration":210000000,"first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00Z"}`
 	assert.Equal(t, expected, string(js))
 }
 
@@ -381,3 +391,2652 @@ root > [Async] > Task - 50µs
 root > Regular - 50µs`
 	assert.Equal(t, expected, result)
 }
+
+func Test_SubtractOverhead(t *testing.T) {
+	overhead := CalibrateOverhead()
+	assert.Greater(t, overhead, time.Duration(0))
+	assert.Equal(t, overhead, CalibrateOverhead())
+
+	ctx := context.Background()
+
+	rootCtx := Root(ctx)
+	noopCtx := ForName(rootCtx, "noop")
+	for i := 0; i < 100; i++ {
+		noopCtx.Start()()
+	}
+
+	var reported time.Duration
+	captureFmt := func(d time.Duration) string {
+		reported = d
+		return d.String()
+	}
+
+	rootCtx.Report(ReportOptions{DurationFormatter: captureFmt})
+	assert.Greater(t, reported, time.Duration(0))
+	withOverhead := reported
+
+	rootCtx.Report(ReportOptions{DurationFormatter: captureFmt, SubtractOverhead: true})
+	assert.Less(t, reported, withOverhead)
+	assert.Less(t, reported, 100*time.Microsecond)
+
+	noopCtx.TotalDuration = 0
+	assert.Equal(t, "noop - 0s calls: 100 (0s/call)", rootCtx.Report(ReportOptions{SubtractOverhead: true}))
+
+	// A fake clock doesn't affect the calibration.
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	restore := setClock(&clock)
+	assert.Greater(t, measureOverhead(), time.Duration(0))
+	restore()
+}
+
+func Test_Transaction(t *testing.T) {
+	ctx := context.Background()
+
+	loc, err := Transaction(ctx, "request", func(ctx context.Context) error {
+		_, complete := Start(ctx, "child")
+		complete()
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "request", loc.Name)
+	assert.Equal(t, uint64(1), loc.EntryCount)
+	assert.Equal(t, uint64(1), loc.ExitCount)
+	assert.Equal(t, uint64(1), loc.Children["child"].ExitCount)
+
+	expectedErr := fmt.Errorf("failed")
+	loc, err = Transaction(ctx, "request", func(ctx context.Context) error {
+		return expectedErr
+	})
+	assert.Equal(t, expectedErr, err)
+	assert.Equal(t, uint64(1), loc.ExitCount)
+}
+
+func Test_TransactionPanic(t *testing.T) {
+	ctx := context.Background()
+
+	loc, err := Transaction(ctx, "request", func(ctx context.Context) error {
+		_, complete := Start(ctx, "child")
+		complete()
+		panic("boom")
+	})
+	assert.EqualError(t, err, "panic in transaction request: boom")
+	assert.Equal(t, uint64(1), loc.EntryCount)
+	assert.Equal(t, uint64(1), loc.ExitCount)
+	assert.Equal(t, uint64(1), loc.Children["child"].ExitCount)
+	assert.Equal(t, "boom", loc.Details["panic"])
+}
+
+func Test_TimeWait(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, complete := Start(ctx, "root")
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		wg.Done()
+	}()
+	TimeWait(rootCtx, "wait", &wg)
+	complete()
+
+	wait := rootCtx.Children["wait"]
+	assert.Equal(t, uint64(1), wait.EntryCount)
+	assert.Equal(t, uint64(1), wait.ExitCount)
+	assert.GreaterOrEqual(t, wait.TotalDuration, 20*time.Millisecond)
+	assert.Less(t, wait.TotalDuration, 500*time.Millisecond)
+}
+
+func Test_AddDetailIfSlow(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx := Root(ctx)
+
+	evaluated := false
+	fastCtx, fastComplete := Start(rootCtx, "fast")
+	fastCtx.AddDetailIfSlow("plan", time.Hour, func() anything {
+		evaluated = true
+		return "full plan"
+	})
+	fastComplete()
+	assert.False(t, evaluated)
+	assert.Nil(t, fastCtx.Details)
+
+	slowCtx, slowComplete := Start(rootCtx, "slow")
+	slowCtx.AddDetailIfSlow("plan", time.Millisecond, func() anything {
+		return "full plan"
+	})
+	time.Sleep(5 * time.Millisecond)
+	slowComplete()
+	assert.Equal(t, "full plan", slowCtx.Details["plan"])
+
+	// The pending detail is consumed by the completion
+	_, slowComplete = Start(rootCtx, "fast")
+	time.Sleep(5 * time.Millisecond)
+	slowComplete()
+	assert.Nil(t, fastCtx.Details)
+}
+
+func Test_Parent(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	childCtx, childComplete := Start(rootCtx, "child")
+	grandchildCtx, grandchildComplete := Start(childCtx, "grandchild")
+	grandchildComplete()
+	childComplete()
+	rootComplete()
+
+	assert.Nil(t, rootCtx.Parent())
+	assert.Same(t, rootCtx.Location, childCtx.Parent())
+	assert.Same(t, childCtx.Location, grandchildCtx.Parent())
+
+	_, err := json.Marshal(rootCtx)
+	assert.NoError(t, err)
+}
+
+func Test_ChildDurationCDF(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	durations := map[string]time.Duration{
+		"a": 10 * time.Millisecond,
+		"b": 50 * time.Millisecond,
+		"c": 30 * time.Millisecond,
+		"d": 10 * time.Millisecond,
+	}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		childCtx, complete := Start(rootCtx, name)
+		complete()
+		childCtx.TotalDuration = durations[name]
+	}
+	rootComplete()
+
+	cdf := rootCtx.ChildDurationCDF()
+	assert.Len(t, cdf, 4)
+	assert.Equal(t, "b", cdf[0].Name)
+	assert.Equal(t, "c", cdf[1].Name)
+	assert.Equal(t, "a", cdf[2].Name)
+	assert.Equal(t, "d", cdf[3].Name)
+
+	assert.InDelta(t, 0.5, cdf[0].Fraction, 1e-9)
+	assert.InDelta(t, 0.5, cdf[0].Cumulative, 1e-9)
+	assert.InDelta(t, 0.3, cdf[1].Fraction, 1e-9)
+	assert.InDelta(t, 0.8, cdf[1].Cumulative, 1e-9)
+	assert.InDelta(t, 0.9, cdf[2].Cumulative, 1e-9)
+	assert.InDelta(t, 1.0, cdf[3].Cumulative, 1e-9)
+
+	sum := 0.0
+	for _, share := range cdf {
+		sum += share.Fraction
+	}
+	assert.InDelta(t, 1.0, sum, 1e-9)
+
+	emptyCtx := Root(ctx)
+	assert.Empty(t, emptyCtx.ChildDurationCDF())
+}
+
+func Test_CachedPath(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	childCtx, childComplete := Start(rootCtx, "child")
+	grandchildCtx, grandchildComplete := Start(childCtx, "grandchild")
+	grandchildComplete()
+	childComplete()
+	rootComplete()
+
+	assert.Equal(t, "root", rootCtx.CachedPath())
+	assert.Equal(t, "root > child > grandchild", grandchildCtx.CachedPath())
+	assert.Equal(t, "root.child.grandchild", grandchildCtx.cachedPath("."))
+
+	childCtx.Rename("renamed")
+	assert.Equal(t, "root > renamed > grandchild", grandchildCtx.CachedPath())
+	assert.Equal(t, []string{"renamed"}, rootCtx.CallOrder)
+	assert.Same(t, childCtx.Location, rootCtx.Children["renamed"])
+
+	newCtx, newComplete := Start(childCtx, "new")
+	newComplete()
+	assert.Equal(t, "root > renamed > new", newCtx.CachedPath())
+
+	m := rootCtx.ReportMap(" > ", 1, false)
+	assert.Len(t, m, 4)
+	assert.Contains(t, m, "root > renamed > grandchild")
+	assert.Contains(t, m, "root > renamed > new")
+
+	// Reporting on a subtree uses paths relative to the subtree
+	m = childCtx.ReportMap(" > ", 1, false)
+	assert.Contains(t, m, "renamed > grandchild")
+
+	unnamedCtx := Root(ctx)
+	unnamedChildCtx, unnamedComplete := Start(unnamedCtx, "child")
+	unnamedComplete()
+	assert.Equal(t, "child", unnamedChildCtx.CachedPath())
+
+	assert.Panics(t, func() {
+		newCtx.Rename("grandchild")
+	})
+	assert.Panics(t, func() {
+		newCtx.Rename("")
+	})
+}
+
+func Test_GetByPath(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	asyncCtx, asyncComplete := StartAsync(rootCtx, "async")
+	leafCtx, leafComplete := Start(asyncCtx, "leaf")
+	leafComplete()
+	asyncComplete()
+	rootComplete()
+
+	assert.Same(t, rootCtx.Location, rootCtx.GetByPath("root", ""))
+	assert.Same(t, leafCtx.Location, rootCtx.GetByPath("root > async > leaf", ""))
+	assert.Same(t, leafCtx.Location, rootCtx.GetByPath("root > [async] > leaf", ""))
+	assert.Same(t, leafCtx.Location, rootCtx.GetByPath("root/[async]/leaf", "/"))
+	assert.Same(t, asyncCtx.Location, asyncCtx.GetByPath("[async]", ""))
+	assert.Nil(t, rootCtx.GetByPath("root > [async] > [leaf]", ""))
+	assert.Nil(t, rootCtx.GetByPath("[root] > async", ""))
+	assert.Nil(t, rootCtx.GetByPath("other > async", ""))
+
+	// The path of every location in the report leads back to it.
+	for _, line := range strings.Split(rootCtx.String(), "\n") {
+		path := strings.SplitN(line, " - ", 2)[0]
+		assert.NotNil(t, rootCtx.GetByPath(path, ""), path)
+	}
+
+	unnamed := Root(ctx)
+	childCtx, childComplete := StartAsync(unnamed, "child")
+	childComplete()
+	assert.Same(t, childCtx.Location, unnamed.GetByPath("[child]", ""))
+}
+
+func buildBenchmarkTree() *Context {
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	for i := 0; i < 10; i++ {
+		childCtx, childComplete := Start(rootCtx, "child "+strconv.Itoa(i))
+		for j := 0; j < 10; j++ {
+			_, grandchildComplete := Start(childCtx, "grandchild "+strconv.Itoa(j))
+			grandchildComplete()
+		}
+		childComplete()
+	}
+	rootComplete()
+	return rootCtx
+}
+
+func Benchmark_ReportMapCached(b *testing.B) {
+	rootCtx := buildBenchmarkTree()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rootCtx.ReportMap(" > ", 1, false)
+	}
+}
+
+func Benchmark_ReportMapUncached(b *testing.B) {
+	rootCtx := buildBenchmarkTree()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rootCtx.invalidatePath()
+		rootCtx.ReportMap(" > ", 1, false)
+	}
+}
+
+// Benchmark_StartWhileReporting measures starting existing children while the tree is being reported
+// on continuously on another Goroutine, which is what the read lock is meant to speed up.
+func Benchmark_StartWhileReporting(b *testing.B) {
+	rootCtx := buildBenchmarkTree()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = rootCtx.String()
+			}
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			childCtx, complete := Start(rootCtx, "child "+strconv.Itoa(i%10))
+			_, grandchildComplete := Start(childCtx, "grandchild "+strconv.Itoa(i%10))
+			grandchildComplete()
+			complete()
+			i++
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-done
+}
+
+// setClock replaces the clock used for timing with one that returns the value pointed to by t.
+// The returned function restores the real clock.
+func setClock(t *time.Time) func() {
+	now = func() time.Time {
+		return *t
+	}
+	return func() {
+		now = time.Now
+	}
+}
+
+func Test_Since(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	oldCtx, oldComplete := Start(rootCtx, "old")
+	clock = clock.Add(10 * time.Millisecond)
+	oldComplete()
+
+	clock = clock.Add(time.Minute)
+	cutoff := clock
+
+	clock = clock.Add(time.Second)
+	_, newInOldComplete := Start(oldCtx, "new in old")
+	clock = clock.Add(20 * time.Millisecond)
+	newInOldComplete()
+
+	newCtx, newComplete := Start(rootCtx, "new")
+	clock = clock.Add(30 * time.Millisecond)
+	newCtx.AddDetails("items", 3)
+	newComplete()
+	rootComplete()
+
+	recent := rootCtx.Since(cutoff)
+	expected := `root > old > new in old - 20ms
+root > new - 30ms (items:3)`
+	assert.Equal(t, expected, recent.String())
+	assert.Equal(t, uint64(0), recent.EntryCount)
+	assert.Same(t, recent, recent.Children["new"].Parent())
+
+	// The original tree is untouched
+	recent.Children["new"].AddDetails("extra", true)
+	assert.Len(t, newCtx.Details, 1)
+
+	everything := rootCtx.Since(cutoff.Add(-time.Hour))
+	assert.Equal(t, rootCtx.String(), everything.String())
+
+	nothing := rootCtx.Since(clock)
+	assert.Equal(t, "root", nothing.Name)
+	assert.Empty(t, nothing.Children)
+}
+
+func Test_WarnAsyncMisuse(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	child1Ctx, c1Complete := Start(rootCtx, "child 1")
+	child2Ctx, c2Complete := Start(rootCtx, "child 2")
+	c1Complete()
+	c2Complete()
+	rootComplete()
+
+	rootCtx.TotalDuration = 110 * time.Millisecond
+	child1Ctx.TotalDuration = 100 * time.Millisecond
+	child2Ctx.TotalDuration = 100 * time.Millisecond
+
+	expected := `root - -90ms [children exceed parent — should this be Async?]
+root > child 1 - 100ms
+root > child 2 - 100ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{ExcludeChildren: true, WarnAsyncMisuse: true}))
+
+	expected = `root - 110ms
+root > child 1 - 100ms
+root > child 2 - 100ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{}))
+
+	expected = `root - 0s (clamped; children exceed by 90ms)
+root > child 1 - 100ms
+root > child 2 - 100ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{ExcludeChildren: true, ClampNegative: true}))
+
+	rootCtx.Async = true
+	expected = `[root] - 110ms
+[root] > child 1 - 100ms
+[root] > child 2 - 100ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{ExcludeChildren: true, WarnAsyncMisuse: true, ClampNegative: true}))
+}
+
+func Test_Origin(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx := Root(ctx)
+	uncaptured, complete := Start(rootCtx, "uncaptured")
+	complete()
+	assert.Nil(t, uncaptured.Origin())
+	assert.Equal(t, "", uncaptured.OriginString())
+
+	CaptureOrigins(true)
+	defer CaptureOrigins(false)
+
+	captured, complete := Start(rootCtx, "captured")
+	complete()
+	assert.NotEmpty(t, captured.Origin())
+	assert.Contains(t, captured.OriginString(), "go-timing.Test_Origin")
+	assert.Contains(t, captured.OriginString(), "timing_test.go:")
+
+	// Only the first start is captured
+	origin := captured.Origin()
+	func() {
+		_, complete := Start(rootCtx, "captured")
+		complete()
+	}()
+	assert.Equal(t, origin, captured.Origin())
+}
+
+func Test_InstrumentationReport(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	loopCtx, loopComplete := Start(rootCtx, "loop")
+	for i := 0; i < 1000; i++ {
+		_, itemComplete := Start(loopCtx, "item")
+		itemComplete()
+	}
+	loopComplete()
+	_, fetchComplete := Start(rootCtx, "fetch")
+	fetchComplete()
+	Start(rootCtx, "unfinished")
+	rootComplete()
+
+	expected := `root - entries: 1 exits: 1 children: 3 child entries: 3
+root > loop - entries: 1 exits: 1 children: 1 child entries: 1000 [item entered 1000x per entry, is this a loop?]
+root > loop > item - entries: 1000 exits: 1000 children: 0 child entries: 0
+root > fetch - entries: 1 exits: 1 children: 0 child entries: 0
+root > unfinished - entries: 1 exits: 0 children: 0 child entries: 0 [incomplete]`
+	assert.Equal(t, expected, rootCtx.InstrumentationReport())
+}
+
+func Test_Section(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	clock = clock.Add(10 * time.Millisecond)
+
+	lockComplete := rootCtx.Section("lock")
+	clock = clock.Add(20 * time.Millisecond)
+	lockComplete()
+
+	ioComplete := rootCtx.Section("io")
+	clock = clock.Add(30 * time.Millisecond)
+	ioComplete()
+
+	lockComplete = rootCtx.Section("lock")
+	clock = clock.Add(5 * time.Millisecond)
+	lockComplete()
+
+	clock = clock.Add(10 * time.Millisecond)
+	rootComplete()
+
+	assert.Equal(t, "root - 75ms (sections: lock 25ms, io 30ms)", rootCtx.String())
+	assert.Equal(t, 25*time.Millisecond, rootCtx.Sections["lock"])
+	assert.Equal(t, 30*time.Millisecond, rootCtx.Sections["io"])
+	assert.LessOrEqual(t, int64(rootCtx.Sections["lock"]+rootCtx.Sections["io"]), int64(rootCtx.TotalDuration))
+
+	assert.Panics(t, func() {
+		lockComplete()
+	})
+	assert.Panics(t, func() {
+		rootCtx.Section("")
+	})
+
+	js, err := json.Marshal(rootCtx)
+	assert.NoError(t, err)
+	assert.Contains(t, string(js), `"sections":{"io":30000000,"lock":25000000}`)
+}
+
+func Test_DetailsInOrder(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	rootComplete()
+
+	rootCtx.TotalDuration = time.Millisecond
+	rootCtx.AddDetails("step3", "fetch")
+	rootCtx.AddDetails("step1", "parse")
+	rootCtx.AddDetails("step2", "render")
+	rootCtx.AddDetails("step3", "fetch again")
+	rootCtx.Details["direct"] = true
+
+	assert.Equal(t, "root - 1ms (direct:true, step1:parse, step2:render, step3:fetch again)", rootCtx.String())
+	assert.Equal(t, "root - 1ms (step3:fetch again, step1:parse, step2:render, direct:true)", rootCtx.Report(ReportOptions{DetailsInOrder: true}))
+}
+
+func Test_CompareReport(t *testing.T) {
+	ctx := context.Background()
+
+	makeTree := func(durations map[string]time.Duration, children ...string) *Context {
+		rootCtx, rootComplete := Start(ctx, "root")
+		for _, name := range children {
+			childCtx, complete := Start(rootCtx, name)
+			complete()
+			childCtx.TotalDuration = durations[name]
+		}
+		rootComplete()
+		rootCtx.TotalDuration = durations["root"]
+		return rootCtx
+	}
+
+	baseline := makeTree(map[string]time.Duration{
+		"root":    200 * time.Millisecond,
+		"changed": 100 * time.Millisecond,
+		"removed": 50 * time.Millisecond,
+	}, "changed", "removed")
+	current := makeTree(map[string]time.Duration{
+		"root":    250 * time.Millisecond,
+		"changed": 150 * time.Millisecond,
+		"added":   80 * time.Millisecond,
+	}, "added", "changed")
+
+	expected := `root - 200ms / 250ms (+25%)
+root > changed - 100ms / 150ms (+50%)
+root > removed - 50ms / — (removed)
+root > added - — / 80ms (added)`
+	assert.Equal(t, expected, CompareReport(baseline.Location, current.Location, ReportOptions{}))
+
+	expected = `root - 50ms / 20ms (-60%)
+ | changed - 100ms / 150ms (+50%)
+ | removed - 50ms / — (removed)
+ | added - — / 80ms (added)`
+	assert.Equal(t, expected, CompareReport(baseline.Location, current.Location, ReportOptions{Compact: true, ExcludeChildren: true}))
+
+	// Either run may be missing.
+	assert.Contains(t, CompareReport(nil, current.Location, ReportOptions{}), "root - — / 250ms (added)")
+	assert.Equal(t, "", CompareReport(nil, nil, ReportOptions{}))
+}
+
+func Test_DurationConcurrent(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := StartAsync(ctx, "root")
+	childCtx := ForName(rootCtx, "child")
+
+	const workers = 50
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < workers*10; i++ {
+			assert.GreaterOrEqual(t, int64(childCtx.Duration()), int64(0))
+			assert.GreaterOrEqual(t, int64(rootCtx.TotalChildDuration()), int64(0))
+		}
+	}()
+
+	finished := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := 0; j < 10; j++ {
+				childCtx.Start()()
+			}
+			finished <- struct{}{}
+		}()
+	}
+	for i := 0; i < workers; i++ {
+		<-finished
+	}
+	<-done
+	rootComplete()
+
+	assert.Equal(t, childCtx.TotalDuration, childCtx.Duration())
+	assert.Equal(t, uint64(workers*10), childCtx.ExitCount)
+}
+
+func Test_CounterPastUint32(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, complete := Start(context.Background(), "root")
+	complete()
+	rootCtx.EntryCount = math.MaxUint32
+	rootCtx.ExitCount = math.MaxUint32
+	rootCtx.TotalDuration = math.MaxUint32 * time.Millisecond
+
+	// This call would wrap the counts around to zero if they were uint32.
+	complete = rootCtx.Start()
+	clock = clock.Add(time.Millisecond)
+	complete()
+
+	assert.Equal(t, uint64(math.MaxUint32+1), rootCtx.Entries())
+	assert.Equal(t, uint64(math.MaxUint32+1), rootCtx.Exits())
+	assert.Equal(t, "root - 1193h2m47.296s calls: 4294967296 (1ms/call)", rootCtx.String())
+}
+
+func Test_AsyncFanOut(t *testing.T) {
+	var total, fastest, slowest int64
+	fastest = math.MaxInt64
+	ctx := WithOnComplete(context.Background(), func(path string, d time.Duration, l *Location) {
+		if path != "fan-out > task" {
+			return
+		}
+		atomic.AddInt64(&total, int64(d))
+		for {
+			current := atomic.LoadInt64(&fastest)
+			if int64(d) >= current || atomic.CompareAndSwapInt64(&fastest, current, int64(d)) {
+				break
+			}
+		}
+		for {
+			current := atomic.LoadInt64(&slowest)
+			if int64(d) <= current || atomic.CompareAndSwapInt64(&slowest, current, int64(d)) {
+				break
+			}
+		}
+	})
+
+	rootCtx, rootComplete := StartAsync(ctx, "fan-out")
+	const workers = 200
+	const iterations = 25
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				_, complete := Start(rootCtx, "task")
+				complete()
+			}
+		}()
+	}
+	wg.Wait()
+	rootComplete()
+
+	task := rootCtx.Children["task"]
+	assert.Len(t, rootCtx.Children, 1)
+	assert.Equal(t, []string{"task"}, rootCtx.CallOrder)
+	assert.Equal(t, uint64(workers*iterations), task.Entries())
+	assert.Equal(t, uint64(workers*iterations), task.Exits())
+	assert.Equal(t, time.Duration(total), task.Duration())
+	assert.Equal(t, time.Duration(slowest), task.MaxCall())
+	if fastest > 0 {
+		assert.Equal(t, time.Duration(fastest), task.MinCall())
+	}
+}
+
+func Test_ReportWhileRunning(t *testing.T) {
+	rootCtx, rootComplete := StartAsync(context.Background(), "root")
+
+	const workers = 50
+	const iterations = 20
+	stop := make(chan struct{})
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		options := []ReportOptions{
+			{},
+			{Compact: true, DetailsInOrder: true, ShowPercentages: true, ShowMinMax: true},
+			{TreeStyle: true, ExcludeChildren: true, Explain: true, ShowSiblingRank: true},
+			{SeparateAsync: true, SortBy: SortByDurationDesc, MaxDepth: 2, HideUnentered: true},
+		}
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			assert.NotEmpty(t, rootCtx.Report(options[i%len(options)]))
+			assert.NotEmpty(t, CompareReport(rootCtx.Location, rootCtx.Location, options[i%len(options)]))
+			_, err := json.Marshal(rootCtx)
+			assert.NoError(t, err)
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				sharedCtx, sharedComplete := Start(rootCtx, "shared")
+				sharedCtx.IncrementDetail("calls", 1)
+				ownCtx, ownComplete := Start(sharedCtx, "worker "+strconv.Itoa(i))
+				ownCtx.AddDetails("iteration", j)
+				ownCtx.Note("iteration " + strconv.Itoa(j))
+				ownComplete()
+				sharedComplete()
+				asyncCtx, asyncComplete := StartAsync(rootCtx, "async "+strconv.Itoa(j%3))
+				_, taskComplete := Start(asyncCtx, "task")
+				taskComplete()
+				asyncComplete()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	<-readerDone
+	rootComplete()
+
+	assert.Equal(t, int64(workers*iterations), rootCtx.Children["shared"].Details["calls"])
+	assert.True(t, rootCtx.Children["async 0"].Async)
+	assert.Contains(t, rootCtx.String(), "[root] > [async 0] > task")
+}
+
+func Test_StartConcurrentRace(t *testing.T) {
+	rootCtx, rootComplete := StartAsync(context.Background(), "root")
+
+	const workers = 300
+	const iterations = 20
+	stop := make(chan struct{})
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			assert.GreaterOrEqual(t, int64(rootCtx.TotalChildDuration()), int64(0))
+			for _, c := range rootCtx.snapshotChildren() {
+				assert.GreaterOrEqual(t, c.Entries(), c.Exits())
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				sharedCtx, sharedComplete := Start(rootCtx, "shared")
+				_, ownComplete := Start(sharedCtx, "worker "+strconv.Itoa(i))
+				ownComplete()
+				sharedComplete()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	<-readerDone
+	rootComplete()
+
+	shared := rootCtx.Children["shared"]
+	assert.Len(t, rootCtx.Children, 1)
+	assert.Equal(t, uint64(workers*iterations), shared.Entries())
+	assert.Equal(t, uint64(workers*iterations), shared.Exits())
+	assert.Len(t, shared.Children, workers)
+	assert.Len(t, shared.CallOrder, workers)
+	for _, c := range shared.snapshotChildren() {
+		assert.Equal(t, uint64(iterations), c.Exits())
+	}
+}
+
+func Test_ContextWithTiming(t *testing.T) {
+	ctx := context.Background()
+
+	outer := ContextWithTiming(ctx, "")
+	_, complete := Start(outer, "first")
+	complete()
+
+	inner := ContextWithTiming(context.WithValue(outer, 1, "value"), "")
+	assert.Same(t, outer.Location, inner.Location)
+	assert.Equal(t, "value", inner.Value(1))
+	_, complete = Start(inner, "second")
+	complete()
+
+	grouped := ContextWithTiming(inner, "library")
+	_, complete = Start(grouped, "third")
+	complete()
+
+	assert.Equal(t, []string{"first", "second", "library"}, outer.CallOrder)
+	assert.Equal(t, uint64(1), outer.Children["library"].Children["third"].ExitCount)
+
+	assert.Panics(t, func() {
+		ContextWithTiming(nil, "")
+	})
+}
+
+func Test_Expire(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx := Root(context.Background())
+	for _, name := range []string{"/a", "/b", "/c"} {
+		_, complete := Start(rootCtx, name)
+		complete()
+	}
+	placeholder := ForName(rootCtx, "group")
+	ForName(rootCtx, "never")
+
+	clock = clock.Add(10 * time.Minute)
+	_, complete := Start(rootCtx, "/b")
+	complete()
+	_, complete = Start(placeholder, "/d")
+	complete()
+
+	clock = clock.Add(time.Minute)
+	rootCtx.Expire(5*time.Minute, clock)
+
+	assert.Equal(t, []string{"/b", "group"}, rootCtx.CallOrder)
+	assert.Len(t, rootCtx.Children, 2)
+	assert.Contains(t, rootCtx.Children, "/b")
+	assert.Contains(t, rootCtx.Children["group"].Children, "/d")
+
+	clock = clock.Add(time.Hour)
+	rootCtx.Expire(5*time.Minute, clock)
+	assert.Empty(t, rootCtx.CallOrder)
+	assert.Empty(t, rootCtx.Children)
+}
+
+func Test_ChildLess(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	for _, c := range []struct {
+		name     string
+		priority int
+	}{{"a", 2}, {"b", 1}, {"c", 2}, {"d", 0}} {
+		childCtx, complete := Start(rootCtx, c.name)
+		complete()
+		childCtx.TotalDuration = time.Millisecond
+		childCtx.AddDetails("priority", c.priority)
+
+		if c.name == "a" {
+			for _, gc := range []struct {
+				name     string
+				priority int
+			}{{"x", 5}, {"y", 3}} {
+				grandchildCtx, complete := Start(childCtx, gc.name)
+				complete()
+				grandchildCtx.TotalDuration = time.Millisecond
+				grandchildCtx.AddDetails("priority", gc.priority)
+			}
+		}
+	}
+	rootComplete()
+	rootCtx.TotalDuration = 10 * time.Millisecond
+
+	byPriority := func(a, b *Location) bool {
+		return a.Details["priority"].(int) < b.Details["priority"].(int)
+	}
+
+	expected := `root - 10ms
+root > d - 1ms (priority:0)
+root > b - 1ms (priority:1)
+root > a - 1ms (priority:2)
+root > a > y - 1ms (priority:3)
+root > a > x - 1ms (priority:5)
+root > c - 1ms (priority:2)`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{ChildLess: byPriority}))
+	assert.Equal(t, []string{"a", "b", "c", "d"}, rootCtx.CallOrder)
+}
+
+func Test_DetectSuspend(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+	defer DetectSuspend(0, false)
+
+	rootCtx := Root(context.Background())
+
+	DetectSuspend(time.Hour, false)
+	flaggedCtx, complete := Start(rootCtx, "flagged")
+	clock = clock.Add(5 * time.Hour)
+	complete()
+	assert.Equal(t, 5*time.Hour, flaggedCtx.Duration())
+	assert.Equal(t, 5*time.Hour, flaggedCtx.Details[SuspectedSuspendDetail])
+
+	DetectSuspend(time.Hour, true)
+	clampedCtx, complete := Start(rootCtx, "clamped")
+	clock = clock.Add(5 * time.Hour)
+	complete()
+	_, complete = Start(rootCtx, "clamped")
+	clock = clock.Add(time.Minute)
+	complete()
+	assert.Equal(t, time.Hour+time.Minute, clampedCtx.Duration())
+	assert.Equal(t, 5*time.Hour, clampedCtx.Details[SuspectedSuspendDetail])
+
+	DetectSuspend(0, false)
+	plainCtx, complete := Start(rootCtx, "plain")
+	clock = clock.Add(5 * time.Hour)
+	complete()
+	assert.Equal(t, 5*time.Hour, plainCtx.Duration())
+	assert.Nil(t, plainCtx.Details)
+}
+
+func Test_OpenMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	childCtx, childComplete := Start(rootCtx, `child "1"`)
+	childComplete()
+	_, childComplete = Start(rootCtx, `child "1"`)
+	childComplete()
+	rootComplete()
+	ForName(rootCtx, "unstarted")
+
+	rootCtx.TotalDuration = 250 * time.Millisecond
+	childCtx.TotalDuration = 100 * time.Millisecond
+	rootCtx.AddDetails("trace-id", "abc123")
+
+	expected := `# TYPE timing_duration_seconds histogram
+# UNIT timing_duration_seconds seconds
+# HELP timing_duration_seconds Time spent in each timing location.
+timing_duration_seconds_bucket{path="root",le="+Inf"} 1 # {trace_id="abc123"} 0.25
+timing_duration_seconds_count{path="root"} 1
+timing_duration_seconds_sum{path="root"} 0.25
+timing_duration_seconds_bucket{path="root > child \"1\"",le="+Inf"} 2
+timing_duration_seconds_count{path="root > child \"1\""} 2
+timing_duration_seconds_sum{path="root > child \"1\""} 0.1
+# EOF
+`
+	assert.Equal(t, expected, rootCtx.OpenMetrics(OMOptions{TraceIDDetail: "trace-id"}))
+
+	expected = `# TYPE request_seconds histogram
+# UNIT request_seconds seconds
+# HELP request_seconds Request timings.
+request_seconds_bucket{path="root",le="+Inf"} 1
+request_seconds_count{path="root"} 1
+request_seconds_sum{path="root"} 0.15
+request_seconds_bucket{path="root/child \"1\"",le="+Inf"} 2
+request_seconds_count{path="root/child \"1\""} 2
+request_seconds_sum{path="root/child \"1\""} 0.1
+# EOF
+`
+	assert.Equal(t, expected, rootCtx.OpenMetrics(OMOptions{Name: "request_seconds", Help: "Request timings.", Separator: "/", ExcludeChildren: true}))
+
+	// A sum is never negative, even if the children add up to more than the parent.
+	childCtx.TotalDuration = 300 * time.Millisecond
+	assert.Contains(t, rootCtx.OpenMetrics(OMOptions{ExcludeChildren: true}), "timing_duration_seconds_sum{path=\"root\"} 0\n")
+}
+
+func Test_SeparateAsync(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	fetchCtx, fetchComplete := Start(rootCtx, "fetch")
+	fetchComplete()
+	workersCtx, workersComplete := StartAsync(rootCtx, "workers")
+	worker1Ctx, w1Complete := Start(workersCtx, "worker 1")
+	w1Complete()
+	worker2Ctx, w2Complete := Start(workersCtx, "worker 2")
+	w2Complete()
+	workersComplete()
+	renderCtx, renderComplete := Start(rootCtx, "render")
+	renderComplete()
+	rootComplete()
+
+	rootCtx.TotalDuration = 200 * time.Millisecond
+	fetchCtx.TotalDuration = 50 * time.Millisecond
+	workersCtx.TotalDuration = 100 * time.Millisecond
+	worker1Ctx.TotalDuration = 90 * time.Millisecond
+	worker2Ctx.TotalDuration = 80 * time.Millisecond
+	renderCtx.TotalDuration = 40 * time.Millisecond
+
+	expected := `root - 200ms
+root > fetch - 50ms
+root > render - 40ms
+--- async: [workers] ---
+root > [workers] - 100ms
+root > [workers] > worker 1 - 90ms
+root > [workers] > worker 2 - 80ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{SeparateAsync: true}))
+
+	expected = `root - 200ms
+ | fetch - 50ms
+ | render - 40ms
+--- async: [workers] ---
+ | [workers] - 100ms
+ |  | worker 1 - 90ms
+ |  | worker 2 - 80ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{SeparateAsync: true, Compact: true}))
+}
+
+func Test_EffectiveParallelism(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	workerCtx, workerComplete := StartAsync(rootCtx, "worker")
+	for i, d := range []time.Duration{100, 120, 80} {
+		taskCtx, complete := Start(workerCtx, "task "+strconv.Itoa(i+1))
+		complete()
+		taskCtx.TotalDuration = d * time.Millisecond
+	}
+	workerComplete()
+	rootComplete()
+
+	rootCtx.TotalDuration = 120 * time.Millisecond
+	workerCtx.TotalDuration = 110 * time.Millisecond
+
+	assert.InDelta(t, 2.727, workerCtx.EffectiveParallelism(), 0.001)
+	assert.InDelta(t, 0.917, rootCtx.EffectiveParallelism(), 0.001)
+
+	expected := `root - 120ms
+root > [worker] - 110ms (≈2.7x parallel)
+root > [worker] > task 1 - 100ms
+root > [worker] > task 2 - 120ms
+root > [worker] > task 3 - 80ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{ShowParallelism: true}))
+
+	assert.Equal(t, 0.0, Root(ctx).EffectiveParallelism())
+}
+
+func Test_ReportDeterministic(t *testing.T) {
+	makeTree := func(order []string) *Context {
+		rootCtx, rootComplete := Start(context.Background(), "root")
+		for _, name := range order {
+			childCtx, complete := Start(rootCtx, name)
+			complete()
+			childCtx.TotalDuration = time.Millisecond
+			for _, detail := range order {
+				childCtx.AddDetails(detail, 1)
+			}
+			for _, grandchild := range order {
+				grandchildCtx, complete := Start(childCtx, grandchild)
+				complete()
+				grandchildCtx.TotalDuration = time.Microsecond
+			}
+		}
+		rootComplete()
+		rootCtx.TotalDuration = 10 * time.Millisecond
+		return rootCtx
+	}
+
+	tree1 := makeTree([]string{"a", "b", "c"})
+	tree2 := makeTree([]string{"c", "a", "b"})
+
+	options := ReportOptions{DetailsInOrder: true}
+	assert.NotEqual(t, tree1.Report(options), tree2.Report(options))
+	assert.Equal(t, tree1.ReportDeterministic(options), tree2.ReportDeterministic(options))
+	assert.Equal(t, tree1.Report(ReportOptions{}), tree1.ReportDeterministic(options))
+}
+
+func Test_Note(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	vendorCtx, vendorComplete := Start(rootCtx, "vendor")
+	vendorComplete()
+	rootComplete()
+
+	rootCtx.TotalDuration = 60 * time.Millisecond
+	vendorCtx.TotalDuration = 50 * time.Millisecond
+	vendorCtx.Note("first note")
+	vendorCtx.Note("waiting on vendor API")
+	vendorCtx.AddDetails("retries", 2)
+
+	expected := `root - 60ms
+root > vendor - 50ms // waiting on vendor API (retries:2)`
+	assert.Equal(t, expected, rootCtx.String())
+
+	expected = `root - 60ms
+root > vendor - 50ms (retries:2)`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{HideNotes: true}))
+
+	js, err := json.Marshal(vendorCtx)
+	assert.NoError(t, err)
+	assert.Contains(t, string(js), `"note":"waiting on vendor API"`)
+}
+
+func Test_ConfidenceInterval(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx := Root(context.Background())
+	queryCtx := ForName(rootCtx, "query")
+	queryCtx.RecordSamples(5)
+
+	low, high := queryCtx.ConfidenceInterval(0.95)
+	assert.Equal(t, time.Duration(0), low)
+	assert.Equal(t, time.Duration(0), high)
+
+	// The first sample is pushed out of the buffer by the last one.
+	for _, d := range []time.Duration{1000, 40, 45, 50, 55, 60} {
+		complete := queryCtx.Start()
+		clock = clock.Add(d * time.Millisecond)
+		complete()
+	}
+	assert.Equal(t, []time.Duration{40 * time.Millisecond, 45 * time.Millisecond, 50 * time.Millisecond, 55 * time.Millisecond, 60 * time.Millisecond}, queryCtx.Samples())
+
+	low, high = queryCtx.ConfidenceInterval(0.95)
+	assert.InDelta(t, 43.07, float64(low)/float64(time.Millisecond), 0.01)
+	assert.InDelta(t, 56.93, float64(high)/float64(time.Millisecond), 0.01)
+
+	low, high = queryCtx.ConfidenceInterval(1)
+	assert.Equal(t, time.Duration(0), low)
+	assert.Equal(t, time.Duration(0), high)
+
+	roundFmt := func(d time.Duration) string {
+		return d.Round(time.Millisecond).String()
+	}
+	assert.Equal(t, "query - 1.25s calls: 6 (208ms/call) (95% CI: 43ms–57ms)", rootCtx.Report(ReportOptions{ConfidenceLevel: 0.95, DurationFormatter: roundFmt}))
+	assert.Equal(t, "query - 1.25s calls: 6 (208ms/call)", rootCtx.Report(ReportOptions{DurationFormatter: roundFmt}))
+
+	queryCtx.RecordSamples(0)
+	assert.Empty(t, queryCtx.Samples())
+}
+
+func Test_MaxCall(t *testing.T) {
+	rootCtx := Root(context.Background())
+	queryCtx := ForName(rootCtx, "query")
+
+	wg := sync.WaitGroup{}
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			complete := queryCtx.Start()
+			time.Sleep(time.Duration(i) * time.Millisecond)
+			complete()
+		}(i)
+	}
+	wg.Wait()
+
+	assert.GreaterOrEqual(t, queryCtx.MaxCall(), 20*time.Millisecond)
+	assert.Less(t, queryCtx.MaxCall(), queryCtx.Duration())
+}
+
+func Test_MaxCallReport(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx := Root(context.Background())
+	for _, d := range []time.Duration{10, 80, 30} {
+		_, complete := Start(rootCtx, "query")
+		clock = clock.Add(d * time.Millisecond)
+		complete()
+	}
+	_, complete := Start(rootCtx, "once")
+	clock = clock.Add(5 * time.Millisecond)
+	complete()
+
+	assert.Equal(t, 80*time.Millisecond, rootCtx.Children["query"].MaxCall())
+	assert.Equal(t, "query - 120ms calls: 3 (40ms/call) (max 80ms)\nonce - 5ms", rootCtx.Report(ReportOptions{ShowMaxCall: true}))
+	assert.Equal(t, "query - 120ms calls: 3 (40ms/call)\nonce - 5ms", rootCtx.Report(ReportOptions{}))
+}
+
+func Test_MarshalJSONWith(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	childCtx, childComplete := Start(rootCtx, "child")
+	grandchildCtx, grandchildComplete := Start(childCtx, "grandchild")
+	grandchildComplete()
+	childComplete()
+	fastCtx, fastComplete := Start(rootCtx, "fast")
+	fastComplete()
+	rootComplete()
+
+	rootCtx.TotalDuration = 210 * time.Millisecond
+	childCtx.TotalDuration = 200 * time.Millisecond
+	grandchildCtx.TotalDuration = 100 * time.Millisecond
+	fastCtx.TotalDuration = time.Microsecond
+	childCtx.AddDetails("rows", 10)
+
+	// With no options the output matches the default JSON.
+	js, err := rootCtx.MarshalJSONWith(MarshalOptions{})
+	assert.NoError(t, err)
+	expected, _ := json.Marshal(rootCtx)
+	assert.Equal(t, string(expected), string(js))
+
+	js, err = rootCtx.MarshalJSONWith(MarshalOptions{MaxDepth: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"root","children":{"child":{"name":"child","entry-count":1,"exit-count":1,"total-duration":200000000,"details":{"rows":10},"first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00Z"},"fast":{"name":"fast","entry-count":1,"exit-count":1,"total-duration":1000,"first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00Z"}},"entry-count":1,"exit-count":1,"total-duration":210000000,"first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00Z"}`, string(js))
+
+	js, err = rootCtx.MarshalJSONWith(MarshalOptions{MaxDepth: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"root","entry-count":1,"exit-count":1,"total-duration":210000000,"first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00Z"}`, string(js))
+
+	js, err = rootCtx.MarshalJSONWith(MarshalOptions{ExcludeDetails: true, MinDuration: time.Millisecond, DurationsAsStrings: true})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"root","children":{"child":{"name":"child","children":{"grandchild":{"name":"grandchild","entry-count":1,"exit-count":1,"total-duration":"100ms","first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00Z"}},"entry-count":1,"exit-count":1,"total-duration":"200ms","first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00Z"}},"entry-count":1,"exit-count":1,"total-duration":"210ms","first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00Z"}`, string(js))
+}
+
+func Test_Explain(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	childCtx, childComplete := Start(rootCtx, "child")
+	childComplete()
+	workersCtx, workersComplete := StartAsync(rootCtx, "workers")
+	workerCtx, workerComplete := Start(workersCtx, "worker")
+	workerComplete()
+	workersComplete()
+	rootComplete()
+
+	rootCtx.TotalDuration = 210 * time.Millisecond
+	childCtx.TotalDuration = 100 * time.Millisecond
+	workersCtx.TotalDuration = 100 * time.Millisecond
+	workerCtx.TotalDuration = 150 * time.Millisecond
+
+	expected := `root - 10ms (self; total 210ms − children 200ms)
+root > child - 100ms
+root > [workers] - 100ms (total; children not subtracted since async)
+root > [workers] > worker - 150ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{ExcludeChildren: true, Explain: true}))
+
+	expected = `root - 210ms (total, including children)
+root > child - 100ms
+root > [workers] - 100ms (total, including children)
+root > [workers] > worker - 150ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{Explain: true}))
+}
+
+func Test_HotTree(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	durations := map[string]time.Duration{
+		"a": 600 * time.Millisecond,
+		"b": 300 * time.Millisecond,
+		"c": 30 * time.Millisecond,
+		"d": 20 * time.Millisecond,
+		"e": 20 * time.Millisecond,
+	}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		childCtx, childComplete := Start(rootCtx, name)
+		if name == "b" {
+			grandchildCtx, grandchildComplete := Start(childCtx, "b1")
+			grandchildComplete()
+			grandchildCtx.TotalDuration = 250 * time.Millisecond
+		}
+		childComplete()
+		childCtx.TotalDuration = durations[name]
+	}
+	rootComplete()
+	rootCtx.TotalDuration = time.Second
+
+	hot := rootCtx.HotTree(0.9)
+	expected := `root - 1s
+root > a - 600ms
+root > b - 300ms
+root > b > b1 - 250ms`
+	assert.Equal(t, expected, hot.String())
+	assert.Same(t, hot, hot.Children["b"].Parent())
+
+	// The original is untouched
+	assert.Len(t, rootCtx.Children, 5)
+
+	assert.Equal(t, "root - 1s", rootCtx.HotTree(0).String())
+	assert.Len(t, rootCtx.HotTree(1).Children, 5)
+
+	// An Async location's own time includes that of its children, so the time spent in "b" counts
+	// in full rather than only what's left over from "b1".
+	rootCtx.Children["b"].Async = true
+	rootCtx.TotalDuration = 1500 * time.Millisecond
+	expected = `root - 1.5s
+root > a - 600ms
+root > [b] - 300ms`
+	assert.Equal(t, expected, rootCtx.HotTree(0.8).String())
+}
+
+func Test_AlignedFormatter(t *testing.T) {
+	f := AlignedFormatter(time.Millisecond, 8, 2)
+	assert.Equal(t, "  210.00ms", f(210*time.Millisecond))
+	assert.Equal(t, "    0.00ms", f(0))
+	assert.Equal(t, "    0.05ms", f(50*time.Microsecond))
+	assert.Equal(t, "12345.68ms", f(12345678*time.Microsecond))
+	assert.Equal(t, "123456.00ms", f(123456*time.Millisecond))
+
+	f = AlignedFormatter(time.Second, 5, 1)
+	assert.Equal(t, "  1.5s", f(1500*time.Millisecond))
+	assert.Equal(t, "-10.0s", f(-10*time.Second))
+
+	assert.Equal(t, "  3µs", AlignedFormatter(time.Microsecond, 3, 0)(3*time.Microsecond))
+
+	assert.PanicsWithValue(t, "unsupported unit for aligned formatter", func() {
+		AlignedFormatter(10*time.Millisecond, 8, 2)
+	})
+}
+
+func Test_WithOnComplete(t *testing.T) {
+	type call struct {
+		path string
+		d    time.Duration
+	}
+	mu := sync.Mutex{}
+	var calls []call
+	ctx := WithOnComplete(context.Background(), func(path string, d time.Duration, l *Location) {
+		assert.Equal(t, path, l.CachedPath())
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call{path, d})
+	})
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	workersCtx, workersComplete := StartAsync(rootCtx, "workers")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, complete := Start(workersCtx, "worker")
+			time.Sleep(time.Millisecond)
+			complete()
+		}()
+	}
+	wg.Wait()
+	workersComplete()
+	// Timings that are not started under the callback context don't call it.
+	_, complete := Start(Root(context.Background()), "other")
+	complete()
+	rootComplete()
+
+	assert.Len(t, calls, 12)
+	counts := map[string]int{}
+	var workerTotal time.Duration
+	for _, c := range calls {
+		counts[c.path]++
+		if c.path == "root > workers > worker" {
+			assert.GreaterOrEqual(t, c.d, time.Millisecond)
+			workerTotal += c.d
+		}
+	}
+	assert.Equal(t, map[string]int{"root": 1, "root > workers": 1, "root > workers > worker": 10}, counts)
+	assert.Equal(t, workersCtx.Children["worker"].Duration(), workerTotal)
+	assert.Equal(t, "root", calls[11].path)
+	assert.Equal(t, rootCtx.Duration(), calls[11].d)
+}
+
+func Test_MarshalJSONWithSelfDuration(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	childCtx, childComplete := Start(rootCtx, "child")
+	childComplete()
+	workersCtx, workersComplete := StartAsync(rootCtx, "workers")
+	workerCtx, workerComplete := Start(workersCtx, "worker")
+	workerComplete()
+	workersComplete()
+	rootComplete()
+
+	rootCtx.TotalDuration = 200 * time.Millisecond
+	childCtx.TotalDuration = 100 * time.Millisecond
+	workersCtx.TotalDuration = 100 * time.Millisecond
+	workerCtx.TotalDuration = 150 * time.Millisecond
+
+	js, err := rootCtx.MarshalJSONWith(MarshalOptions{IncludeSelfDuration: true, DurationsAsStrings: true})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"root","children":{"child":{"name":"child","entry-count":1,"exit-count":1,"total-duration":"100ms","self-duration":"100ms","first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00Z"},"workers":{"name":"workers","children":{"worker":{"name":"worker","entry-count":1,"exit-count":1,"total-duration":"150ms","self-duration":"150ms","first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00Z"}},"entry-count":1,"exit-count":1,"total-duration":"100ms","self-duration":"100ms","async":true,"first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00Z"}},"entry-count":1,"exit-count":1,"total-duration":"200ms","self-duration":"0s","first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00Z"}`, string(js))
+
+	var parsed struct {
+		SelfDuration int64 `json:"self-duration"`
+	}
+	js, err = childCtx.MarshalJSONWith(MarshalOptions{IncludeSelfDuration: true})
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(js, &parsed))
+	assert.Equal(t, int64(100*time.Millisecond), parsed.SelfDuration)
+
+	// Children that add up to more than their parent don't make its self duration negative.
+	childCtx.TotalDuration = 300 * time.Millisecond
+	js, err = rootCtx.MarshalJSONWith(MarshalOptions{IncludeSelfDuration: true})
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(js, &parsed))
+	assert.Equal(t, int64(0), parsed.SelfDuration)
+}
+
+func Test_ShowSiblingRank(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	child1Ctx, child1Complete := Start(rootCtx, "child 1")
+	child1Complete()
+	child2Ctx, child2Complete := Start(rootCtx, "child 2")
+	grandchildCtx, grandchildComplete := Start(child2Ctx, "grandchild")
+	grandchildComplete()
+	child2Complete()
+	rootComplete()
+
+	rootCtx.TotalDuration = 160 * time.Millisecond
+	child1Ctx.TotalDuration = 100 * time.Millisecond
+	child2Ctx.TotalDuration = 50 * time.Millisecond
+	grandchildCtx.TotalDuration = 20 * time.Millisecond
+
+	expected := `root - 160ms
+root > child 1 - 100ms (1/2, 2.0× fastest)
+root > child 2 - 50ms (2/2, 1.0× fastest)
+root > child 2 > grandchild - 20ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{ShowSiblingRank: true}))
+
+	child2Ctx.TotalDuration = 0
+	expected = `root - 160ms
+root > child 1 - 100ms (1/2)
+root > child 2 - 0s (2/2)
+root > child 2 > grandchild - 20ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{ShowSiblingRank: true}))
+}
+
+func Test_MinMax(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx := Root(context.Background())
+	for _, d := range []time.Duration{30, 5, 80, 20} {
+		_, complete := Start(rootCtx, "query")
+		clock = clock.Add(d * time.Millisecond)
+		complete()
+	}
+	_, complete := Start(rootCtx, "once")
+	clock = clock.Add(10 * time.Millisecond)
+	complete()
+
+	queryCtx := rootCtx.Children["query"]
+	assert.Equal(t, 5*time.Millisecond, queryCtx.MinDuration)
+	assert.Equal(t, 80*time.Millisecond, queryCtx.MaxDuration)
+	assert.Equal(t, 5*time.Millisecond, queryCtx.MinCall())
+
+	expected := "query - 135ms calls: 4 (33.75ms/call) (min 5ms, max 80ms)\nonce - 10ms"
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{ShowMinMax: true, ShowMaxCall: true}))
+
+	js, err := json.Marshal(rootCtx.Children["once"])
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"once","entry-count":1,"exit-count":1,"total-duration":10000000,"min-duration":10000000,"max-duration":10000000,"first-entry":"2023-01-01T00:00:00.135Z","last-exit":"2023-01-01T00:00:00.145Z"}`, string(js))
+
+	// A location that was never completed leaves them out.
+	js, err = json.Marshal(ForName(rootCtx, "never"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"never"}`, string(js))
+}
+
+func Test_SortBy(t *testing.T) {
+	ctx := context.Background()
+
+	rootCtx, rootComplete := Start(ctx, "root")
+	durations := map[string]time.Duration{
+		"b": 20 * time.Millisecond,
+		"c": 50 * time.Millisecond,
+		"a": 20 * time.Millisecond,
+		"y": 2 * time.Millisecond,
+		"x": 1 * time.Millisecond,
+	}
+	for _, name := range []string{"b", "c", "a"} {
+		childCtx, childComplete := Start(rootCtx, name)
+		for _, grandchild := range []string{"y", "x"} {
+			grandchildCtx, grandchildComplete := Start(childCtx, grandchild)
+			grandchildComplete()
+			grandchildCtx.TotalDuration = durations[grandchild]
+		}
+		childComplete()
+		childCtx.TotalDuration = durations[name]
+	}
+	rootComplete()
+	rootCtx.TotalDuration = 100 * time.Millisecond
+
+	expected := `root - 100ms
+ | c - 50ms
+ |  | y - 2ms
+ |  | x - 1ms
+ | b - 20ms
+ |  | y - 2ms
+ |  | x - 1ms
+ | a - 20ms
+ |  | y - 2ms
+ |  | x - 1ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{Compact: true, SortBy: SortByDurationDesc}))
+
+	expected = `root - 100ms
+ | a - 20ms
+ |  | x - 1ms
+ |  | y - 2ms
+ | b - 20ms
+ |  | x - 1ms
+ |  | y - 2ms
+ | c - 50ms
+ |  | x - 1ms
+ |  | y - 2ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{Compact: true, SortBy: SortByName}))
+
+	expected = `root - 100ms
+ | b - 20ms
+ |  | y - 2ms
+ |  | x - 1ms
+ | c - 50ms
+ |  | y - 2ms
+ |  | x - 1ms
+ | a - 20ms
+ |  | y - 2ms
+ |  | x - 1ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{Compact: true}))
+	assert.Equal(t, []string{"b", "c", "a"}, rootCtx.CallOrder)
+}
+
+func Test_MaxDepth(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	aCtx, aComplete := Start(rootCtx, "a")
+	bCtx, bComplete := Start(aCtx, "b")
+	for _, name := range []string{"c1", "c2"} {
+		cCtx, cComplete := Start(bCtx, name)
+		_, dComplete := Start(cCtx, "d")
+		clock = clock.Add(10 * time.Millisecond)
+		dComplete()
+		cComplete()
+	}
+	bComplete()
+	aComplete()
+	_, leafComplete := Start(rootCtx, "leaf")
+	clock = clock.Add(5 * time.Millisecond)
+	leafComplete()
+	rootComplete()
+
+	expected := `root - 25ms
+root > a - 20ms
+root > a > b - 20ms (+4 deeper)
+root > leaf - 5ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{MaxDepth: 3}))
+	assert.Equal(t, "root - 25ms (+7 deeper)", rootCtx.Report(ReportOptions{MaxDepth: 1}))
+
+	// The unnamed root isn't a level of its own
+	root := Root(context.Background())
+	root.Children = map[string]*Location{"root": rootCtx.Location}
+	root.CallOrder = []string{"root"}
+	assert.Equal(t, "root - 25ms (+7 deeper)", root.Report(ReportOptions{MaxDepth: 1}))
+
+	// Reporting a subtree counts from the subtree
+	expected = `b - 20ms
+b > c1 - 10ms (+1 deeper)
+b > c2 - 10ms (+1 deeper)`
+	assert.Equal(t, expected, bCtx.Report(ReportOptions{MaxDepth: 2}))
+}
+
+func Test_MinDuration(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	wrapperCtx, wrapperComplete := Start(rootCtx, "wrapper")
+	wrapperCtx.AddDetails("hidden", true)
+	_, slowComplete := Start(wrapperCtx, "slow")
+	clock = clock.Add(50 * time.Millisecond)
+	slowComplete()
+	clock = clock.Add(time.Millisecond)
+	wrapperComplete()
+	_, fastComplete := Start(rootCtx, "fast")
+	clock = clock.Add(time.Millisecond)
+	fastComplete()
+	rootComplete()
+
+	expected := `root - 0s
+root > wrapper > slow - 50ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{ExcludeChildren: true, MinDuration: 10 * time.Millisecond}))
+
+	expected = `root - 52ms
+root > wrapper - 51ms (hidden:true)
+root > wrapper > slow - 50ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{MinDuration: 10 * time.Millisecond}))
+}
+
+func Test_ShowPercentages(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	childCtx, childComplete := Start(rootCtx, "child")
+	_, grandchildComplete := Start(childCtx, "grandchild")
+	clock = clock.Add(20 * time.Millisecond)
+	grandchildComplete()
+	clock = clock.Add(30 * time.Millisecond)
+	childComplete()
+	_, emptyComplete := Start(childCtx, "empty")
+	emptyComplete()
+	clock = clock.Add(50 * time.Millisecond)
+	rootComplete()
+
+	expected := `root - 100ms (100% of total)
+root > child - 50ms (50% of parent, 50% of total)
+root > child > grandchild - 20ms (40% of parent, 20% of total)
+root > child > empty - 0s (0% of parent, 0% of total)`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{ShowPercentages: true}))
+
+	expected = `child - 50ms (100% of total)
+child > grandchild - 20ms (40% of parent, 40% of total)
+child > empty - 0s (0% of parent, 0% of total)`
+	assert.Equal(t, expected, childCtx.Report(ReportOptions{ShowPercentages: true}))
+
+	// An unnamed root has no duration of its own, so the total is that of its children.
+	root := Root(context.Background())
+	_, complete := Start(root, "a")
+	clock = clock.Add(30 * time.Millisecond)
+	complete()
+	_, complete = Start(root, "b")
+	clock = clock.Add(10 * time.Millisecond)
+	complete()
+	assert.Equal(t, "a - 30ms (75% of total)\nb - 10ms (25% of total)", root.Report(ReportOptions{ShowPercentages: true}))
+
+	// Zero durations don't divide by zero
+	zero := Root(context.Background())
+	zeroCtx, complete := Start(zero, "zero")
+	_, childComplete = Start(zeroCtx, "child")
+	childComplete()
+	complete()
+	assert.Equal(t, "zero - 0s\nzero > child - 0s", zero.Report(ReportOptions{ShowPercentages: true}))
+}
+
+func Test_FirstEntryLastExit(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	assert.True(t, rootCtx.LastExit().IsZero())
+
+	// Overlapping calls: the one that was started last completes first.
+	_, complete1 := Start(rootCtx, "child")
+	clock = clock.Add(10 * time.Millisecond)
+	childCtx, complete2 := Start(rootCtx, "child")
+	clock = clock.Add(10 * time.Millisecond)
+	complete2()
+	clock = clock.Add(10 * time.Millisecond)
+	complete1()
+	rootComplete()
+
+	assert.True(t, childCtx.FirstEntry().Equal(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, childCtx.LastExit().Equal(time.Date(2023, 1, 1, 0, 0, 0, 30_000_000, time.UTC)))
+
+	js, err := json.Marshal(childCtx.Location)
+	assert.NoError(t, err)
+	assert.Contains(t, string(js), `"first-entry":"2023-01-01T00:00:00Z","last-exit":"2023-01-01T00:00:00.03Z"`)
+
+	parsed, err := ParseReport(js)
+	assert.NoError(t, err)
+	assert.True(t, parsed.FirstEntry().Equal(childCtx.FirstEntry()))
+	assert.True(t, parsed.LastExit().Equal(childCtx.LastExit()))
+
+	// Concurrent completions keep the latest exit.
+	loc := &Location{Name: "concurrent"}
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			loc.updateLastExit(int64(i))
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, time.Unix(0, 100), loc.LastExit())
+}
+
+func Test_IncrementDetail(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	rootCtx.AddDetails("batches", 2)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rootCtx.IncrementDetail("rows", 3)
+			rootCtx.AddDetailDuration("waiting", 10*time.Millisecond)
+		}()
+	}
+	wg.Wait()
+	rootCtx.IncrementDetail("batches", 1)
+	rootComplete()
+
+	assert.Equal(t, int64(300), rootCtx.Details["rows"])
+	assert.Equal(t, int64(3), rootCtx.Details["batches"])
+	assert.Equal(t, time.Second, rootCtx.Details["waiting"])
+	assert.Equal(t, "root - 0s (batches:3, rows:300, waiting:1s)", rootCtx.String())
+
+	rootCtx.AddDetails("name", "x")
+	assert.PanicsWithValue(t, "detail name is not an integer", func() {
+		rootCtx.IncrementDetail("name", 1)
+	})
+	assert.PanicsWithValue(t, "detail rows is not a duration", func() {
+		rootCtx.AddDetailDuration("rows", time.Second)
+	})
+}
+
+func Test_DetailFormatters(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	rootCtx.AddDetails("size", 4_200_000)
+	rootCtx.AddDetails("wait", 1500*time.Millisecond)
+	rootCtx.AddDetails("user", struct{ ID int }{ID: 7})
+	rootComplete()
+
+	assert.Equal(t, "root - 0s (size:4200000, user:{ID:7}, wait:1.5s)", rootCtx.String())
+
+	options := ReportOptions{
+		DurationFormatter: AlignedFormatter(time.Millisecond, 0, 0),
+		DetailFormatters: map[string]DetailFormatter{
+			"size": FormatBytes,
+			"user": func(value interface{}) string {
+				return fmt.Sprintf("#%d", value.(struct{ ID int }).ID)
+			},
+		},
+	}
+	assert.Equal(t, "root - 0ms (size:4.2MB, user:#7, wait:1500ms)", rootCtx.Report(options))
+}
+
+func Test_FormatBytes(t *testing.T) {
+	assert.Equal(t, "512B", FormatBytes(512))
+	assert.Equal(t, "1.5kB", FormatBytes(int64(1500)))
+	assert.Equal(t, "4.2MB", FormatBytes(uint64(4_200_000)))
+	assert.Equal(t, "3.0GB", FormatBytes(uint32(3_000_000_000)))
+	assert.Equal(t, "-2.0kB", FormatBytes(-2000))
+	assert.Equal(t, "many", FormatBytes("many"))
+}
+
+func Test_TopN(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	aCtx, complete := Start(rootCtx, "a")
+	_, leafComplete := Start(aCtx, "leaf")
+	clock = clock.Add(50 * time.Millisecond)
+	leafComplete()
+	clock = clock.Add(5 * time.Millisecond)
+	complete()
+	for i := 0; i < 2; i++ {
+		_, complete = Start(rootCtx, "b")
+		clock = clock.Add(20 * time.Millisecond)
+		complete()
+	}
+	rootComplete()
+
+	assert.Equal(t, []LocationSummary{
+		{Path: "root", Duration: 95 * time.Millisecond, Calls: 1},
+		{Path: "root > a", Duration: 55 * time.Millisecond, Calls: 1},
+	}, rootCtx.TopN(2, false))
+
+	assert.Equal(t, []LocationSummary{
+		{Path: "root > a > leaf", Duration: 50 * time.Millisecond, Calls: 1},
+		{Path: "root > b", Duration: 40 * time.Millisecond, Calls: 2},
+		{Path: "root > a", Duration: 5 * time.Millisecond, Calls: 1},
+		{Path: "root", Duration: 0, Calls: 1},
+	}, rootCtx.TopN(0, true))
+}
+
+func Test_Color(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	for _, d := range []time.Duration{600, 300, 95, 5} {
+		childCtx, childComplete := Start(rootCtx, fmt.Sprintf("child %d", d))
+		clock = clock.Add(d * time.Millisecond)
+		childComplete()
+		if d == 600 {
+			childCtx.AddDetails("rows", 12)
+			childCtx.AddDetails("query", "SELECT *\nFROM t")
+		}
+	}
+	rootComplete()
+
+	expected := "root - \x1b[31m1s\x1b[0m\n" +
+		"root > child 600 - \x1b[31m600ms\x1b[0m\n" +
+		"    query:SELECT *\n" +
+		"          FROM t\n" +
+		"    rows:12\n" +
+		"root > child 300 - \x1b[33m300ms\x1b[0m\n" +
+		"root > child 95 - 95ms\n" +
+		"root > child 5 - \x1b[2m5ms\x1b[0m"
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{Color: true}))
+
+	assert.NotContains(t, rootCtx.Report(ReportOptions{}), "\x1b")
+}
+
+func Test_HideCounts(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	for i := 0; i < 2; i++ {
+		_, childComplete := Start(rootCtx, "child")
+		clock = clock.Add(50 * time.Millisecond)
+		childComplete()
+	}
+	_, _ = Start(rootCtx, "running")
+	clock = clock.Add(10 * time.Millisecond)
+	rootComplete()
+
+	assert.Equal(t, "root - 110ms\nroot > child - 100ms calls: 2 (50ms/call)\nroot > running - 0s entries: 1 exits: 0",
+		rootCtx.String())
+	assert.Equal(t, "root - 110ms\nroot > child - 100ms\nroot > running - 0s",
+		rootCtx.Report(ReportOptions{HideCounts: true}))
+	assert.Equal(t, uint64(2), rootCtx.Children["child"].Exits())
+}
+
+func Test_AlwaysShowCalls(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	for i := 0; i < 2; i++ {
+		_, childComplete := Start(rootCtx, "child")
+		clock = clock.Add(50 * time.Millisecond)
+		childComplete()
+	}
+	_, _ = Start(rootCtx, "running")
+	rootComplete()
+
+	expected := "root - 100ms calls: 1 (100ms/call)\n" +
+		"root > child - 100ms calls: 2 (50ms/call)\n" +
+		"root > running - 0s entries: 1 exits: 0"
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{AlwaysShowCalls: true}))
+	assert.Equal(t, "root - 100ms\nroot > child - 100ms\nroot > running - 0s",
+		rootCtx.Report(ReportOptions{AlwaysShowCalls: true, HideCounts: true}))
+}
+
+func Test_Round(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	for _, d := range []time.Duration{1234567, 2345678, 345678} {
+		_, childComplete := Start(rootCtx, "child")
+		clock = clock.Add(d)
+		childComplete()
+	}
+	rootComplete()
+
+	assert.Equal(t, "root - 3.925923ms\nroot > child - 3.925923ms calls: 3 (1.308641ms/call)", rootCtx.String())
+	assert.Equal(t, "root - 4ms\nroot > child - 4ms calls: 3 (1ms/call)",
+		rootCtx.Report(ReportOptions{Round: time.Millisecond}))
+	assert.Equal(t, "root - 3.93ms\nroot > child - 3.93ms calls: 3 (1.31ms/call)",
+		rootCtx.Report(ReportOptions{Round: 10 * time.Microsecond}))
+
+	// The rounding is done before the formatter is called.
+	formatter := func(d time.Duration) string { return fmt.Sprintf("%dns", d.Nanoseconds()) }
+	assert.Equal(t, "root - 4000000ns\nroot > child - 4000000ns calls: 3 (1000000ns/call)",
+		rootCtx.Report(ReportOptions{Round: time.Millisecond, DurationFormatter: formatter}))
+}
+
+func Test_TreeStyle(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	child1Ctx, child1Complete := Start(rootCtx, "child 1")
+	child1Ctx.AddDetails("query", "SELECT *\nFROM t")
+	_, leafComplete := Start(child1Ctx, "leaf 1")
+	clock = clock.Add(30 * time.Millisecond)
+	leafComplete()
+	_, leafComplete = Start(child1Ctx, "leaf 2")
+	clock = clock.Add(10 * time.Millisecond)
+	leafComplete()
+	child1Complete()
+	child2Ctx, child2Complete := Start(rootCtx, "child 2")
+	child2Ctx.AddDetails("rows", "1\n2")
+	_, leafComplete = Start(child2Ctx, "leaf")
+	clock = clock.Add(60 * time.Millisecond)
+	leafComplete()
+	child2Complete()
+	rootComplete()
+
+	expected := `root - 100ms
+├── child 1 - 40ms
+│   │    query:SELECT *
+│   │          FROM t
+│   ├── leaf 1 - 30ms
+│   └── leaf 2 - 10ms
+└── child 2 - 60ms
+    │    rows:1
+    │         2
+    └── leaf - 60ms`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{TreeStyle: true}))
+
+	// An unnamed root has no line of its own, so each of its children is drawn as a separate tree.
+	root := Root(context.Background())
+	aCtx, complete := Start(root, "a")
+	_, leafComplete = Start(aCtx, "leaf")
+	leafComplete()
+	complete()
+	_, complete = Start(root, "b")
+	complete()
+	assert.Equal(t, "a - 0s\n└── leaf - 0s\nb - 0s", root.Report(ReportOptions{TreeStyle: true}))
+}
+
+type failingWriter struct {
+	limit int
+	n     int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n+len(p) > w.limit {
+		n := w.limit - w.n
+		w.n = w.limit
+		return n, fmt.Errorf("connection reset")
+	}
+	w.n += len(p)
+	return len(p), nil
+}
+
+func Test_WriteReport(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	_, childComplete := Start(rootCtx, "child")
+	clock = clock.Add(100 * time.Millisecond)
+	childComplete()
+	rootComplete()
+	rootCtx.AddDetails("rows", 5)
+
+	options := ReportOptions{Compact: true, ShowPercentages: true}
+	buf := bytes.Buffer{}
+	n, err := rootCtx.WriteReport(&buf, options)
+	assert.NoError(t, err)
+	assert.Equal(t, rootCtx.Report(options), buf.String())
+	assert.Equal(t, buf.Len(), n)
+
+	w := &failingWriter{limit: 10}
+	n, err = rootCtx.WriteReport(w, options)
+	assert.EqualError(t, err, "connection reset")
+	assert.Equal(t, 10, n)
+}
+
+func Test_Startf(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	for _, table := range []string{"users", "orders", "users"} {
+		_, complete := Startf(rootCtx, "query:%s", table)
+		clock = clock.Add(10 * time.Millisecond)
+		complete()
+	}
+	rootComplete()
+
+	assert.Equal(t, []string{"query:users", "query:orders"}, rootCtx.CallOrder)
+	assert.Equal(t, uint64(2), rootCtx.Children["query:users"].ExitCount)
+	assert.Equal(t, 20*time.Millisecond, rootCtx.Children["query:users"].TotalDuration)
+}
+
+func Test_StartWithDetails(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	childCtx, complete := StartWithDetails(rootCtx, "query", map[string]interface{}{"table": "users", "limit": 10})
+	clock = clock.Add(10 * time.Millisecond)
+	complete()
+	_, complete = StartWithDetails(rootCtx, "query", map[string]interface{}{"limit": 20, "offset": 5})
+	clock = clock.Add(10 * time.Millisecond)
+	complete()
+	rootComplete()
+
+	assert.Equal(t, map[string]anything{"table": "users", "limit": 20, "offset": 5}, childCtx.Details)
+	assert.Equal(t, uint64(2), childCtx.ExitCount)
+	assert.Equal(t, "root - 20ms\nroot > query - 20ms calls: 2 (10ms/call) (limit:20, table:users, offset:5)",
+		rootCtx.Report(ReportOptions{DetailsInOrder: true}))
+}
+
+func Test_Cancellation(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rootCtx, rootComplete := Start(WithCancellationTracking(ctx), "root")
+	rootCtx.AddDetails("cancelled", "a detail of the same name is left alone")
+	_, complete := Start(rootCtx, "finished")
+	complete()
+	_, complete = Start(rootCtx, "aborted")
+	cancel()
+	complete()
+	rootComplete()
+
+	assert.Equal(t, uint64(0), rootCtx.Children["finished"].Cancelled())
+	assert.Equal(t, uint64(1), rootCtx.Children["aborted"].Cancelled())
+	assert.Equal(t, uint64(1), rootCtx.Cancelled())
+	assert.Equal(t, "a detail of the same name is left alone", rootCtx.Details["cancelled"])
+	assert.Contains(t, rootCtx.String(), "root > aborted - 0s [cancelled: 1]")
+
+	js, err := json.Marshal(rootCtx)
+	assert.NoError(t, err)
+	parsed, err := ParseReport(js)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), parsed.Children["aborted"].Cancelled())
+	js, err = rootCtx.MarshalJSONWith(MarshalOptions{IncludeCallOrder: true})
+	assert.NoError(t, err)
+	parsed, err = ParseReport(js)
+	assert.NoError(t, err)
+	assert.Equal(t, rootCtx.String(), parsed.String())
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	rootCtx, rootComplete = Start(WithCancellationTracking(ctx), "root")
+	rootComplete()
+	assert.Equal(t, uint64(1), rootCtx.DeadlineExceeded())
+	assert.Equal(t, uint64(0), rootCtx.Cancelled())
+
+	// Without tracking, nothing is counted.
+	rootCtx, rootComplete = Start(ctx, "root")
+	rootComplete()
+	assert.Equal(t, uint64(0), rootCtx.DeadlineExceeded())
+}
+
+func Test_Get(t *testing.T) {
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	childCtx, childComplete := Start(rootCtx, "child")
+	leafCtx, leafComplete := Start(childCtx, "leaf")
+	leafComplete()
+	childComplete()
+	rootComplete()
+
+	assert.Same(t, rootCtx.Location, rootCtx.Get())
+	assert.Same(t, childCtx.Location, rootCtx.Get("child"))
+	assert.Same(t, leafCtx.Location, rootCtx.Get("child", "leaf"))
+	assert.Nil(t, rootCtx.Get("leaf"))
+	assert.Nil(t, rootCtx.Get("child", "leaf", "missing"))
+
+	assert.Same(t, leafCtx.Location, rootCtx.GetByPath("root > child > leaf", ""))
+	assert.Same(t, leafCtx.Location, rootCtx.GetByPath("root/child/leaf", "/"))
+	assert.Same(t, rootCtx.Location, rootCtx.GetByPath("root", ""))
+	assert.Nil(t, rootCtx.GetByPath("child > leaf", ""))
+	assert.Nil(t, rootCtx.GetByPath("root > leaf", ""))
+
+	// The path of an unnamed root starts with its children.
+	r := Root(context.Background())
+	c1Ctx, c1Complete := Start(r, "c1")
+	c1Complete()
+	assert.Same(t, c1Ctx.Location, r.GetByPath("c1", ""))
+}
+
+func Test_Walk(t *testing.T) {
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	for _, name := range []string{"b", "a"} {
+		childCtx, childComplete := Start(rootCtx, name)
+		_, leafComplete := Start(childCtx, "leaf")
+		leafComplete()
+		childComplete()
+	}
+	rootComplete()
+
+	var visited []string
+	rootCtx.Walk(func(path []string, loc *Location) bool {
+		visited = append(visited, strings.Join(path, "/"))
+		assert.Equal(t, path[len(path)-1], loc.Name)
+		return loc.Name != "a"
+	})
+	assert.Equal(t, []string{"root", "root/b", "root/b/leaf", "root/a"}, visited)
+
+	// An unnamed root is visited with an empty path and left out of the paths of its descendants.
+	r := Root(context.Background())
+	_, complete := Start(r, "child")
+	complete()
+	visited = nil
+	r.Walk(func(path []string, loc *Location) bool {
+		visited = append(visited, strings.Join(path, "/"))
+		return true
+	})
+	assert.Equal(t, []string{"", "child"}, visited)
+}
+
+func Test_Time(t *testing.T) {
+	rootCtx := Root(context.Background())
+
+	called := false
+	c := Time(rootCtx, "db.query", func() {
+		called = true
+	})
+	c.AddDetails("rows", 3)
+	assert.True(t, called)
+	assert.Same(t, rootCtx.Children["db.query"], c.Location)
+	assert.Equal(t, uint64(1), c.ExitCount)
+
+	assert.PanicsWithValue(t, "boom", func() {
+		Time(rootCtx, "db.query", func() {
+			panic("boom")
+		})
+	})
+	assert.Equal(t, uint64(2), c.EntryCount)
+	assert.Equal(t, uint64(2), c.ExitCount)
+}
+
+func Test_TimeErr(t *testing.T) {
+	rootCtx := Root(context.Background())
+
+	err := TimeErr(rootCtx, "fetch", func() error {
+		return nil
+	})
+	assert.NoError(t, err)
+	fetch := rootCtx.Children["fetch"]
+	assert.Equal(t, uint64(1), fetch.ExitCount)
+	assert.Nil(t, fetch.Details)
+
+	expectedErr := fmt.Errorf("not found")
+	err = TimeErr(rootCtx, "fetch", func() error {
+		return expectedErr
+	})
+	assert.Same(t, expectedErr, err)
+	assert.Equal(t, uint64(2), fetch.ExitCount)
+	assert.Equal(t, "not found", fetch.Details["error"])
+
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = TimeErr(rootCtx, "fetch", func() error {
+			panic("boom")
+		})
+	})
+	assert.Equal(t, uint64(3), fetch.EntryCount)
+	assert.Equal(t, uint64(3), fetch.ExitCount)
+}
+
+func Test_TimeValue(t *testing.T) {
+	rootCtx := Root(context.Background())
+
+	rows, err := TimeValue(rootCtx, "query", func() (int, error) {
+		return 42, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 42, rows)
+
+	name, err := TimeValue(rootCtx, "lookup", func() (string, error) {
+		return "", fmt.Errorf("not found")
+	})
+	assert.EqualError(t, err, "not found")
+	assert.Equal(t, "", name)
+	assert.Equal(t, "not found", rootCtx.Children["lookup"].Details["error"])
+
+	assert.PanicsWithValue(t, "boom", func() {
+		_, _ = TimeValue(rootCtx, "query", func() (int, error) {
+			panic("boom")
+		})
+	})
+	query := rootCtx.Children["query"]
+	assert.Equal(t, uint64(2), query.EntryCount)
+	assert.Equal(t, uint64(2), query.ExitCount)
+}
+
+func Test_Elapsed(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx := Root(context.Background())
+	jobCtx := ForName(rootCtx, "job")
+	assert.Equal(t, time.Duration(0), jobCtx.Elapsed())
+
+	complete := jobCtx.Start()
+	clock = clock.Add(3 * time.Second)
+	assert.Equal(t, 3*time.Second, jobCtx.Elapsed())
+	clock = clock.Add(2 * time.Second)
+	assert.Equal(t, 5*time.Second, jobCtx.Elapsed())
+	complete()
+	assert.Equal(t, time.Duration(0), jobCtx.Elapsed())
+	assert.Equal(t, 5*time.Second, jobCtx.Duration())
+}
+
+func Test_ElapsedConcurrent(t *testing.T) {
+	rootCtx := Root(context.Background())
+	jobCtx, complete := Start(rootCtx, "job")
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.GreaterOrEqual(t, jobCtx.Elapsed(), time.Duration(0))
+			}
+		}()
+	}
+	wg.Wait()
+	complete()
+}
+
+func Test_Record(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, complete := Start(context.Background(), "root")
+	rootCtx.Record("db", 30*time.Millisecond)
+	rootCtx.Record("db", 10*time.Millisecond)
+	clock = clock.Add(50 * time.Millisecond)
+	complete()
+
+	db := rootCtx.Children["db"]
+	assert.Equal(t, uint64(2), db.EntryCount)
+	assert.Equal(t, uint64(2), db.ExitCount)
+	assert.Equal(t, 10*time.Millisecond, db.MinDuration)
+	assert.Equal(t, 30*time.Millisecond, db.MaxDuration)
+	assert.Equal(t, []string{"db"}, rootCtx.CallOrder)
+
+	expected := `root - 10ms
+root > db - 40ms calls: 2 (20ms/call)`
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{ExcludeChildren: true}))
+
+	assert.PanicsWithValue(t, "non-root timings must be named", func() {
+		rootCtx.Record("", time.Second)
+	})
+}
+
+func Test_ReportSlice(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, complete := Start(context.Background(), "root")
+	for _, name := range []string{"z", "a", "m"} {
+		childCtx, childComplete := Start(rootCtx, name)
+		if name == "a" {
+			_, grandchildComplete := Start(childCtx, "inner")
+			clock = clock.Add(5 * time.Millisecond)
+			grandchildComplete()
+		}
+		clock = clock.Add(10 * time.Millisecond)
+		childComplete()
+	}
+	complete()
+
+	expected := []ReportEntry{
+		{Key: "root", Value: 0},
+		{Key: "root.z", Value: 10},
+		{Key: "root.a", Value: 10},
+		{Key: "root.a.inner", Value: 5},
+		{Key: "root.m", Value: 10},
+	}
+	assert.Equal(t, expected, rootCtx.ReportSlice(".", 1000000, true))
+
+	m := rootCtx.ReportMap(".", 1000000, true)
+	assert.Len(t, m, len(expected))
+	for _, e := range expected {
+		assert.Equal(t, e.Value, m[e.Key])
+	}
+}
+
+func Test_ReportMapStrict(t *testing.T) {
+	rootCtx := Root(context.Background())
+	aCtx, complete := Start(rootCtx, "a")
+	_, childComplete := Start(aCtx, "b")
+	childComplete()
+	complete()
+
+	m, err := rootCtx.ReportMapStrict(" > ", 1, false)
+	assert.NoError(t, err)
+	assert.Len(t, m, 2)
+
+	_, complete = Start(rootCtx, "a > b")
+	complete()
+
+	assert.Len(t, rootCtx.ReportMap(" > ", 1, false), 2)
+	m, err = rootCtx.ReportMapStrict(" > ", 1, false)
+	assert.EqualError(t, err, `duplicate report key "a > b"; a location name contains the separator " > "`)
+	assert.Nil(t, m)
+
+	m, err = rootCtx.ReportMapStrict(".", 1, false)
+	assert.NoError(t, err)
+	assert.Len(t, m, 3)
+}
+
+func Test_Sampling(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx := Root(context.Background())
+	existingCtx := ForName(rootCtx, "existing")
+	rootCtx.SetSampling(10)
+
+	for i := 0; i < 100; i++ {
+		queryCtx, complete := Start(rootCtx, "query")
+		clock = clock.Add(time.Duration(i%10+1) * time.Millisecond)
+		complete()
+		_, complete = Start(queryCtx, "parse")
+		complete()
+	}
+	_, complete := Start(existingCtx, "nested")
+	complete()
+
+	query := rootCtx.Children["query"]
+	// Only the first of every ten calls is timed, and each of those takes 1ms.
+	assert.Equal(t, uint64(100), query.EntryCount)
+	assert.Equal(t, uint64(100), query.ExitCount)
+	assert.Equal(t, 100*time.Millisecond, query.TotalDuration)
+	assert.Equal(t, time.Millisecond, query.MinDuration)
+	assert.Equal(t, time.Millisecond, query.MaxDuration)
+	assert.Equal(t, uint64(100), query.Children["parse"].ExitCount)
+	assert.Equal(t, uint64(10), existingCtx.Children["nested"].ExitCount)
+
+	rootCtx.SetSampling(0)
+	_, complete = Start(rootCtx, "query")
+	complete()
+	assert.Equal(t, uint64(101), query.ExitCount)
+}
+
+func Test_Merge(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	build := func(children []string, d time.Duration) *Context {
+		rootCtx, complete := Start(context.Background(), "root")
+		for _, name := range children {
+			childCtx, childComplete := Start(rootCtx, name)
+			clock = clock.Add(d)
+			childComplete()
+			childCtx.AddDetails("worker", d.String())
+			childCtx.AddDetails(name, true)
+		}
+		complete()
+		return rootCtx
+	}
+
+	a := build([]string{"fetch", "parse"}, 10*time.Millisecond)
+	b := build([]string{"parse", "store"}, 30*time.Millisecond)
+	b.Children["parse"].Async = true
+
+	a.Merge(b.Location)
+
+	assert.Equal(t, []string{"fetch", "parse", "store"}, a.CallOrder)
+	assert.Equal(t, uint64(2), a.ExitCount)
+	assert.Equal(t, 80*time.Millisecond, a.TotalDuration)
+
+	parse := a.Children["parse"]
+	assert.Equal(t, uint64(2), parse.ExitCount)
+	assert.Equal(t, 40*time.Millisecond, parse.TotalDuration)
+	assert.Equal(t, 10*time.Millisecond, parse.MinDuration)
+	assert.Equal(t, 30*time.Millisecond, parse.MaxDuration)
+	assert.True(t, parse.Async)
+	assert.Equal(t, "30ms", parse.Details["worker"])
+
+	store := a.Children["store"]
+	assert.Same(t, a.Location, store.Parent())
+	assert.NotSame(t, b.Children["store"], store)
+	assert.Equal(t, 30*time.Millisecond, store.TotalDuration)
+
+	// The other tree is untouched
+	assert.Equal(t, uint64(1), b.ExitCount)
+	assert.Len(t, b.Children, 2)
+}
+
+func Test_GroupChildren(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	for i, d := range []time.Duration{10, 30, 20} {
+		userCtx, userComplete := Startf(rootCtx, "user:%d", i)
+		userCtx.AddDetails("id", i)
+		_, loadComplete := Start(userCtx, "load")
+		clock = clock.Add(d * time.Millisecond)
+		loadComplete()
+		userComplete()
+	}
+	_, otherComplete := Start(rootCtx, "other")
+	clock = clock.Add(5 * time.Millisecond)
+	otherComplete()
+	rootComplete()
+
+	grouped := rootCtx.GroupChildren(func(name string) string {
+		if strings.HasPrefix(name, "user:") {
+			return "user"
+		}
+		return ""
+	})
+
+	expected := `root - 65ms
+root > user - 60ms calls: 3 (20ms/call) (id:2)
+root > user > load - 60ms calls: 3 (20ms/call)
+root > other - 5ms`
+	assert.Equal(t, expected, grouped.String())
+	user := grouped.Get("user")
+	assert.Equal(t, 10*time.Millisecond, user.MinCall())
+	assert.Equal(t, 30*time.Millisecond, user.MaxCall())
+
+	// The original tree is unchanged.
+	assert.Len(t, rootCtx.Children, 4)
+}
+
+func Test_Reset(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx := Root(context.Background())
+	batch := func(items int) {
+		batchCtx, batchComplete := Start(rootCtx, "batch")
+		batchCtx.RecordSamples(10)
+		batchCtx.IncrementDetail("items", int64(items))
+		for _, name := range []string{"load", "save"} {
+			_, complete := Start(batchCtx, name)
+			clock = clock.Add(time.Duration(items) * time.Millisecond)
+			complete()
+		}
+		batchComplete()
+	}
+
+	batch(10)
+	assert.Equal(t, "batch - 20ms (items:10)\nbatch > load - 10ms\nbatch > save - 10ms", rootCtx.String())
+
+	batchLoc := rootCtx.Get("batch")
+	rootCtx.Reset()
+	assert.Equal(t, []string{"load", "save"}, batchLoc.CallOrder)
+	assert.Equal(t, time.Duration(0), batchLoc.Get("load").Duration())
+	assert.Nil(t, batchLoc.Details)
+	assert.Equal(t, uint64(0), batchLoc.Entries())
+	assert.Equal(t, time.Duration(0), batchLoc.MaxCall())
+	assert.True(t, batchLoc.FirstEntry().IsZero())
+	assert.Empty(t, batchLoc.Samples())
+
+	// The same locations are reused for the next batch.
+	batch(5)
+	assert.Same(t, batchLoc, rootCtx.Get("batch"))
+	assert.Equal(t, "batch - 10ms (items:5)\nbatch > load - 5ms\nbatch > save - 5ms", rootCtx.String())
+	assert.Equal(t, []time.Duration{10 * time.Millisecond}, batchLoc.Samples())
+}
+
+func Test_Prune(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	ForName(rootCtx, "unused")
+	placeholder := ForName(rootCtx, "placeholder")
+	ForName(placeholder, "unused")
+	_, taskComplete := Start(placeholder, "task")
+	clock = clock.Add(10 * time.Millisecond)
+	taskComplete()
+	rootComplete()
+
+	assert.Equal(t, "root - 10ms\nroot > unused - \nroot > placeholder > unused - \nroot > placeholder > task - 10ms", rootCtx.String())
+
+	expected := "root - 10ms\nroot > placeholder > task - 10ms"
+	assert.Equal(t, expected, rootCtx.Report(ReportOptions{HideUnentered: true}))
+	assert.Len(t, rootCtx.Children, 2)
+
+	rootCtx.Prune()
+	assert.Equal(t, expected, rootCtx.String())
+	assert.Equal(t, []string{"placeholder"}, rootCtx.CallOrder)
+	assert.Equal(t, []string{"task"}, placeholder.CallOrder)
+}
+
+func Test_SelfAndReportedDuration(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, rootComplete := Start(context.Background(), "root")
+	childCtx, childComplete := Start(rootCtx, "child")
+	clock = clock.Add(30 * time.Millisecond)
+	childComplete()
+	clock = clock.Add(10 * time.Millisecond)
+	rootComplete()
+
+	assert.Equal(t, 10*time.Millisecond, rootCtx.SelfDuration())
+	assert.Equal(t, 30*time.Millisecond, childCtx.SelfDuration())
+
+	assert.Equal(t, 10*time.Millisecond, rootCtx.ReportedDuration(true))
+	assert.Equal(t, 40*time.Millisecond, rootCtx.ReportedDuration(false))
+
+	// The children of an Async location are not subtracted.
+	rootCtx.Async = true
+	assert.Equal(t, 40*time.Millisecond, rootCtx.SelfDuration())
+	assert.Equal(t, 40*time.Millisecond, rootCtx.ReportedDuration(true))
+
+	// Children that add up to more than the parent don't make it negative.
+	rootCtx.Async = false
+	childCtx.TotalDuration = time.Second
+	assert.Equal(t, time.Duration(0), rootCtx.SelfDuration())
+	assert.Equal(t, -960*time.Millisecond, rootCtx.ReportedDuration(true))
+}
+
+func Test_Clone(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, complete := Start(context.Background(), "root")
+	childCtx, childComplete := Start(rootCtx, "child")
+	childCtx.AddDetails("rows", 3)
+	clock = clock.Add(10 * time.Millisecond)
+	childComplete()
+
+	clone := rootCtx.Clone()
+	assert.Nil(t, clone.Parent())
+	assert.Equal(t, []string{"child"}, clone.CallOrder)
+	assert.Same(t, clone, clone.Children["child"].Parent())
+
+	// Further timing doesn't affect the clone
+	childCtx.AddDetails("rows", 4)
+	_, otherComplete := Start(rootCtx, "other")
+	otherComplete()
+	clock = clock.Add(5 * time.Millisecond)
+	complete()
+
+	assert.Equal(t, "root - 0s entries: 1 exits: 0\nroot > child - 10ms (rows:3)", clone.String())
+	assert.Equal(t, "root - 15ms\nroot > child - 10ms (rows:4)\nroot > other - 0s", rootCtx.String())
+
+	clone.Children["child"].AddDetails("rows", 5)
+	assert.Equal(t, 4, childCtx.Details["rows"])
+}
+
+func Test_CloneConcurrent(t *testing.T) {
+	rootCtx, complete := Start(context.Background(), "root")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c, childComplete := Start(rootCtx, "child "+strconv.Itoa(j%10))
+				c.AddDetails("worker", i)
+				childComplete()
+			}
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		clone := rootCtx.Clone()
+		_ = clone.String()
+	}
+	wg.Wait()
+	complete()
+	assert.Len(t, rootCtx.Clone().Children, 10)
+}
+
+func Test_ParseReport(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, complete := Start(context.Background(), "root")
+	for _, name := range []string{"a", "b"} {
+		childCtx, childComplete := StartAsync(rootCtx, name)
+		_, grandchildComplete := Start(childCtx, "inner")
+		clock = clock.Add(10 * time.Millisecond)
+		grandchildComplete()
+		childComplete()
+		childCtx.AddDetails("rows", 2)
+	}
+	sectionComplete := rootCtx.Section("lock")
+	clock = clock.Add(5 * time.Millisecond)
+	sectionComplete()
+	complete()
+	rootCtx.Note("cold cache")
+
+	js, err := json.Marshal(rootCtx)
+	assert.NoError(t, err)
+
+	parsed, err := ParseReport(js)
+	assert.NoError(t, err)
+	assert.Equal(t, rootCtx.String(), parsed.String())
+	assert.Equal(t, []string{"a", "b"}, parsed.CallOrder)
+	assert.Same(t, parsed, parsed.Children["a"].Parent())
+	assert.Same(t, parsed.Children["a"], parsed.Children["a"].Children["inner"].Parent())
+	assert.Equal(t, 2.0, parsed.Children["b"].Details["rows"])
+	assert.Equal(t, 10*time.Millisecond, parsed.Children["b"].MaxDuration)
+	assert.Equal(t, "root > b > inner", parsed.Children["b"].Children["inner"].CachedPath())
+
+	_, err = ParseReport([]byte(`{"name":`))
+	assert.Error(t, err)
+}
+
+func Test_CallOrderRoundTrip(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer setClock(&clock)()
+
+	rootCtx, complete := Start(context.Background(), "root")
+	for _, name := range []string{"z", "a", "m"} {
+		childCtx, childComplete := Start(rootCtx, name)
+		for _, inner := range []string{"second", "first"} {
+			_, innerComplete := Start(childCtx, inner)
+			clock = clock.Add(time.Millisecond)
+			innerComplete()
+		}
+		childComplete()
+	}
+	complete()
+
+	js, err := rootCtx.MarshalJSONWith(MarshalOptions{IncludeCallOrder: true})
+	assert.NoError(t, err)
+	assert.Contains(t, string(js), `"call-order":["z","a","m"]`)
+
+	parsed, err := ParseReport(js)
+	assert.NoError(t, err)
+	assert.Equal(t, rootCtx.String(), parsed.String())
+	assert.Equal(t, rootCtx.Report(ReportOptions{Compact: true, ExcludeChildren: true}), parsed.Report(ReportOptions{Compact: true, ExcludeChildren: true}))
+
+	// Children that are missing from the call order are put after the others by name.
+	parsed, err = ParseReport([]byte(`{"name":"root","children":{"a":{"name":"a"},"b":{"name":"b"},"c":{"name":"c"}},"call-order":["c","x"]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "a", "b"}, parsed.CallOrder)
+
+	// The order that the details were added in is restored too.
+	rootCtx.AddDetails("step2", 2)
+	rootCtx.AddDetails("step10", 10)
+	rootCtx.AddDetails("step1", 1)
+	js, err = rootCtx.MarshalJSONWith(MarshalOptions{IncludeCallOrder: true})
+	assert.NoError(t, err)
+	assert.Contains(t, string(js), `"detail-order":["step2","step10","step1"]`)
+	parsed, err = ParseReport(js)
+	assert.NoError(t, err)
+	options := ReportOptions{Compact: true, DetailsInOrder: true}
+	assert.Equal(t, rootCtx.Report(options), parsed.Report(options))
+	assert.Contains(t, parsed.Report(options), "(step2:2, step10:10, step1:1)")
+
+	// The default JSON is unchanged.
+	js, err = json.Marshal(rootCtx)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(js), "call-order")
+	assert.NotContains(t, string(js), "detail-order")
+}
+
+func Test_LocationAlignment(t *testing.T) {
+	// The fields that are accessed with 64-bit atomic operations must be 64-bit aligned, even on
+	// 32-bit platforms. Run with GOARCH=386 to check those.
+	var l Location
+	offsets := map[string]uintptr{
+		"firstEntry":       unsafe.Offsetof(l.firstEntry),
+		"lastEntry":        unsafe.Offsetof(l.lastEntry),
+		"lastExit":         unsafe.Offsetof(l.lastExit),
+		"cancelled":        unsafe.Offsetof(l.cancelled),
+		"deadlineExceeded": unsafe.Offsetof(l.deadlineExceeded),
+		"EntryCount":       unsafe.Offsetof(l.EntryCount),
+		"ExitCount":        unsafe.Offsetof(l.ExitCount),
+		"TotalDuration":    unsafe.Offsetof(l.TotalDuration),
+		"MinDuration":      unsafe.Offsetof(l.MinDuration),
+		"MaxDuration":      unsafe.Offsetof(l.MaxDuration),
+	}
+	for name, offset := range offsets {
+		assert.Zero(t, offset%8, name)
+	}
+
+	var b budget
+	assert.Zero(t, unsafe.Offsetof(b.spent)%8)
+}
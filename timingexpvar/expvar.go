@@ -0,0 +1,20 @@
+// Package timingexpvar publishes go-timing trees with the standard library's expvar package, so that
+// they show up at /debug/vars. This is in its own package since importing expvar registers that
+// handler with http.DefaultServeMux.
+package timingexpvar
+
+import (
+	"expvar"
+
+	timing "github.com/gburgyan/go-timing"
+)
+
+// Publish makes the timing tree available as the expvar variable with the name. Each time the
+// variable is read, such as by a request to /debug/vars, a fresh Clone of the tree is rendered as
+// JSON, so the tree may still be being timed. Like expvar.Publish, this panics if the name is already
+// in use.
+func Publish(name string, root *timing.Location) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return root.Clone()
+	}))
+}
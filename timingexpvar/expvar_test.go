@@ -0,0 +1,34 @@
+package timingexpvar
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	timing "github.com/gburgyan/go-timing"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_Publish(t *testing.T) {
+	rootCtx, rootComplete := timing.Start(context.Background(), "root")
+	Publish("timing-test", rootCtx.Location)
+
+	var published timing.Location
+	assert.NoError(t, json.Unmarshal([]byte(expvar.Get("timing-test").String()), &published))
+	assert.Equal(t, "root", published.Name)
+	assert.Equal(t, uint64(1), published.EntryCount)
+	assert.Equal(t, uint64(0), published.ExitCount)
+	assert.Empty(t, published.Children)
+
+	// Every read renders the current state of the tree.
+	_, childComplete := timing.Start(rootCtx, "child")
+	childComplete()
+	rootComplete()
+	assert.NoError(t, json.Unmarshal([]byte(expvar.Get("timing-test").String()), &published))
+	assert.Equal(t, uint64(1), published.ExitCount)
+	assert.Equal(t, uint64(1), published.Children["child"].ExitCount)
+
+	assert.Panics(t, func() {
+		Publish("timing-test", rootCtx.Location)
+	})
+}
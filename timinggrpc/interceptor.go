@@ -0,0 +1,46 @@
+// Package timinggrpc provides gRPC interceptors that time each RPC with go-timing.
+package timinggrpc
+
+import (
+	"context"
+
+	timing "github.com/gburgyan/go-timing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CompleteFunc is called with the completed timing tree of each RPC, so that it can be logged or
+// exported. The context is the one that was passed to the handler.
+type CompleteFunc func(ctx context.Context, root *timing.Location)
+
+// UnaryServerInterceptor returns an interceptor that times every unary RPC. A new root timing context
+// named after the full method name of the RPC is started and passed to the handler, so the handler
+// can time its own work as children of it. When the handler returns, the status code of the RPC is
+// recorded as the "code" detail, any error message is recorded as the "error" detail, and the
+// completed tree is passed to onComplete if it is not nil. This also happens if the handler panics,
+// in which case the code is recorded as Unknown and the panic is passed on.
+func UnaryServerInterceptor(onComplete CompleteFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		tCtx, complete := timing.StartRoot(ctx, info.FullMethod)
+		returned := false
+		defer func() {
+			complete()
+
+			code := status.Code(err)
+			if !returned {
+				code = codes.Unknown
+			}
+			tCtx.AddDetails("code", code.String())
+			if err != nil {
+				tCtx.AddDetails("error", err.Error())
+			}
+			if onComplete != nil {
+				onComplete(tCtx, tCtx.Location)
+			}
+		}()
+		resp, err = handler(tCtx, req)
+		returned = true
+		return resp, err
+	}
+}
@@ -0,0 +1,80 @@
+package timinggrpc
+
+import (
+	"context"
+	"testing"
+
+	timing "github.com/gburgyan/go-timing"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_UnaryServerInterceptor(t *testing.T) {
+	var completed *timing.Location
+	interceptor := UnaryServerInterceptor(func(ctx context.Context, root *timing.Location) {
+		completed = root
+	})
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/pkg.Service/Method"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, complete := timing.Start(ctx, "db")
+		complete()
+		return "response", nil
+	}
+
+	resp, err := interceptor(context.Background(), "request", info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "response", resp)
+
+	assert.Equal(t, "/pkg.Service/Method", completed.Name)
+	assert.Equal(t, uint64(1), completed.ExitCount)
+	assert.Equal(t, uint64(1), completed.Children["db"].ExitCount)
+	assert.Equal(t, "OK", completed.Details["code"])
+	assert.NotContains(t, completed.Details, "error")
+}
+
+func Test_UnaryServerInterceptorError(t *testing.T) {
+	var completed *timing.Location
+	interceptor := UnaryServerInterceptor(func(ctx context.Context, root *timing.Location) {
+		completed = root
+	})
+
+	// The root is independent of any timing context already on the incoming context.
+	parent, _ := timing.Start(context.Background(), "parent")
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/pkg.Service/Fail"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "missing")
+	}
+
+	_, err := interceptor(parent, "request", info, handler)
+	assert.Error(t, err)
+	assert.Equal(t, "/pkg.Service/Fail", completed.Name)
+	assert.Equal(t, "NotFound", completed.Details["code"])
+	assert.Equal(t, "rpc error: code = NotFound desc = missing", completed.Details["error"])
+	assert.Empty(t, parent.Children)
+
+	_, err = UnaryServerInterceptor(nil)(context.Background(), "request", info, handler)
+	assert.Error(t, err)
+}
+
+func Test_UnaryServerInterceptorPanic(t *testing.T) {
+	var completed *timing.Location
+	interceptor := UnaryServerInterceptor(func(ctx context.Context, root *timing.Location) {
+		completed = root
+	})
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/pkg.Service/Panic"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	}
+
+	assert.PanicsWithValue(t, "boom", func() {
+		_, _ = interceptor(context.Background(), "request", info, handler)
+	})
+	assert.Equal(t, "/pkg.Service/Panic", completed.Name)
+	assert.Equal(t, uint64(1), completed.ExitCount)
+	assert.Equal(t, "Unknown", completed.Details["code"])
+}
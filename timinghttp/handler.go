@@ -0,0 +1,177 @@
+package timinghttp
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	timing "github.com/gburgyan/go-timing"
+)
+
+// Handler returns a handler that serves a report of the timing tree, such as for a /debug/timing
+// endpoint. Each request reports on a fresh Clone of the tree, so the tree may still be being timed and
+// every response is a consistent snapshot.
+//
+// The format is chosen by the Accept header of the request: "application/json" gets the tree as JSON,
+// "text/html" gets a page with the tree as collapsible lists, and anything else gets the plain text
+// report. The "format" query parameter, which is one of "json", "html" or "text", overrides this. The
+// text and HTML reports can be adjusted with these query parameters:
+//
+//   - separator: the ReportOptions.Separator.
+//   - excludeChildren: "true" for ReportOptions.ExcludeChildren.
+//   - compact: "true" for ReportOptions.Compact.
+//   - sort: "call", "duration" or "name" for ReportOptions.SortBy.
+//
+// Invalid query parameters are answered with a 400 Bad Request.
+func Handler(root *timing.Location) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		options, err := reportOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		snapshot := root.Clone()
+
+		switch responseFormat(r) {
+		case "json":
+			data, err := json.Marshal(snapshot)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write(data)
+		case "html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(htmlReport(snapshot, &options)))
+		default:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = snapshot.WriteReport(w, options)
+		}
+	})
+}
+
+// responseFormat returns the format that the request asks for.
+func responseFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/json"):
+		return "json"
+	case strings.Contains(accept, "text/html"):
+		return "html"
+	default:
+		return "text"
+	}
+}
+
+// reportOptions builds the report options from the query parameters of the request.
+func reportOptions(r *http.Request) (timing.ReportOptions, error) {
+	q := r.URL.Query()
+	options := timing.ReportOptions{Separator: q.Get("separator")}
+	var err error
+	if v := q.Get("excludeChildren"); v != "" {
+		if options.ExcludeChildren, err = strconv.ParseBool(v); err != nil {
+			return options, &queryError{"excludeChildren", v}
+		}
+	}
+	if v := q.Get("compact"); v != "" {
+		if options.Compact, err = strconv.ParseBool(v); err != nil {
+			return options, &queryError{"compact", v}
+		}
+	}
+	switch v := q.Get("sort"); v {
+	case "", "call":
+		options.SortBy = timing.SortByCallOrder
+	case "duration":
+		options.SortBy = timing.SortByDurationDesc
+	case "name":
+		options.SortBy = timing.SortByName
+	default:
+		return options, &queryError{"sort", v}
+	}
+	switch v := q.Get("format"); v {
+	case "", "json", "html", "text":
+	default:
+		return options, &queryError{"format", v}
+	}
+	return options, nil
+}
+
+// queryError is returned for a query parameter that has an invalid value.
+type queryError struct {
+	param string
+	value string
+}
+
+func (e *queryError) Error() string {
+	return "invalid value for " + e.param + ": " + strconv.Quote(e.value)
+}
+
+// htmlReport renders the tree as a page of nested collapsible lists.
+func htmlReport(root *timing.Location, options *timing.ReportOptions) string {
+	b := strings.Builder{}
+	b.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>Timing</title></head><body>\n")
+	writeHTMLNode(&b, root, options)
+	b.WriteString("</body></html>\n")
+	return b.String()
+}
+
+// writeHTMLNode recursively writes the location and its children. A location with children is a
+// <details> element that starts out open, and one without is a plain <div>. The location is part of a
+// snapshot, so its fields can be read directly.
+func writeHTMLNode(b *strings.Builder, l *timing.Location, options *timing.ReportOptions) {
+	children := make([]*timing.Location, 0, len(l.CallOrder))
+	for _, name := range l.CallOrder {
+		children = append(children, l.Children[name])
+	}
+	switch options.SortBy {
+	case timing.SortByDurationDesc:
+		sort.SliceStable(children, func(i, j int) bool {
+			return children[i].TotalDuration > children[j].TotalDuration
+		})
+	case timing.SortByName:
+		sort.SliceStable(children, func(i, j int) bool {
+			return children[i].Name < children[j].Name
+		})
+	}
+
+	summary := html.EscapeString(htmlSummary(l, options))
+	if len(children) == 0 {
+		b.WriteString("<div>" + summary + "</div>\n")
+		return
+	}
+	b.WriteString("<details open><summary>" + summary + "</summary>\n")
+	b.WriteString("<div style=\"margin-left:1.5em\">\n")
+	for _, c := range children {
+		writeHTMLNode(b, c, options)
+	}
+	b.WriteString("</div></details>\n")
+}
+
+// htmlSummary is the line that is shown for the location, with its name, duration and call count.
+func htmlSummary(l *timing.Location, options *timing.ReportOptions) string {
+	name := l.Name
+	if name == "" {
+		name = "(root)"
+	} else if l.Async {
+		name = "[" + name + "]"
+	}
+	if l.EntryCount == 0 {
+		return name
+	}
+	d := l.TotalDuration
+	if options.ExcludeChildren {
+		d = l.ReportedDuration(true)
+	}
+	summary := name + " - " + d.String()
+	if l.ExitCount > 1 {
+		summary += " calls: " + strconv.FormatUint(l.ExitCount, 10)
+	}
+	return summary
+}
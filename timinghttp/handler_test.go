@@ -0,0 +1,66 @@
+package timinghttp
+
+import (
+	"context"
+	"encoding/json"
+	timing "github.com/gburgyan/go-timing"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_Handler(t *testing.T) {
+	rootCtx, rootComplete := timing.Start(context.Background(), "root")
+	for _, name := range []string{"b", "a", "b"} {
+		_, complete := timing.Start(rootCtx, name)
+		complete()
+	}
+	rootComplete()
+	rootCtx.TotalDuration = 100 * time.Millisecond
+	rootCtx.Children["a"].TotalDuration = 30 * time.Millisecond
+	rootCtx.Children["b"].TotalDuration = 20 * time.Millisecond
+	handler := Handler(rootCtx.Location)
+
+	serve := func(target, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("/debug/timing", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "root - 100ms\nroot > b - 20ms calls: 2 (10ms/call)\nroot > a - 30ms", rec.Body.String())
+
+	rec = serve("/debug/timing?excludeChildren=true&sort=duration&separator=/", "")
+	assert.Equal(t, "root - 50ms\nroot/a - 30ms\nroot/b - 20ms calls: 2 (10ms/call)", rec.Body.String())
+
+	rec = serve("/debug/timing?compact=true&sort=name", "text/plain")
+	assert.Equal(t, "root - 100ms\n | a - 30ms\n | b - 20ms calls: 2 (10ms/call)", rec.Body.String())
+
+	rec = serve("/debug/timing", "application/json")
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var parsed timing.Location
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &parsed))
+	assert.Equal(t, 30*time.Millisecond, parsed.Children["a"].TotalDuration)
+
+	rec = serve("/debug/timing?sort=name", "text/html,application/xhtml+xml")
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "<details open><summary>root - 100ms</summary>\n"+
+		"<div style=\"margin-left:1.5em\">\n<div>a - 30ms</div>\n<div>b - 20ms calls: 2</div>\n</div></details>\n")
+
+	// The format parameter overrides the Accept header.
+	rec = serve("/debug/timing?format=json", "text/html")
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	for _, target := range []string{"/?compact=maybe", "/?excludeChildren=2", "/?sort=size", "/?format=xml"} {
+		rec = serve(target, "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+}
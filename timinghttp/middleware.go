@@ -0,0 +1,133 @@
+// Package timinghttp provides HTTP middleware that times each request with go-timing.
+package timinghttp
+
+import (
+	"net/http"
+	"strings"
+
+	timing "github.com/gburgyan/go-timing"
+)
+
+// Options configures the timing middleware.
+type Options struct {
+	// Name returns the name of the root timing context for a request. If this is not specified the
+	// default is DefaultName.
+	Name func(r *http.Request) string
+
+	// OnComplete, if specified, is called with the completed timing tree of each request so that it
+	// can be logged or exported.
+	OnComplete func(r *http.Request, root *timing.Location)
+}
+
+// Middleware times every request with the default options. Since the default options have no
+// OnComplete callback, this is only useful if the handlers retrieve the timing context from the
+// request themselves; use Options.Middleware to get the completed trees.
+func Middleware(next http.Handler) http.Handler {
+	return Options{}.Middleware(next)
+}
+
+// Middleware returns a handler that times every request. A new root timing context is started for
+// each request and installed in the request's context, so the downstream handlers can time their
+// own work as children of it. When next returns, or panics, the status code and the size of the
+// response body are recorded as the "status" and "size" details and the completed tree is passed to
+// OnComplete. If next panics before writing the header, the status is recorded as 500, since the
+// request failed.
+func (o Options) Middleware(next http.Handler) http.Handler {
+	nameFn := o.Name
+	if nameFn == nil {
+		nameFn = DefaultName
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tCtx, complete := timing.StartRoot(r.Context(), nameFn(r))
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		r = r.WithContext(tCtx)
+		returned := false
+		defer func() {
+			complete()
+
+			if !returned && !rec.wroteHeader {
+				rec.status = http.StatusInternalServerError
+			}
+			tCtx.AddDetails("status", rec.status)
+			tCtx.AddDetails("size", rec.size)
+			if o.OnComplete != nil {
+				o.OnComplete(r, tCtx.Location)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		returned = true
+	})
+}
+
+// DefaultName names a request after its method and its path, with any path segments that look like
+// identifiers replaced by "{id}", e.g. "GET /users/{id}". This keeps the number of distinct names
+// bounded when the paths contain parameters.
+func DefaultName(r *http.Request) string {
+	return r.Method + " " + SanitizePath(r.URL.Path)
+}
+
+// SanitizePath replaces the segments of the path that look like identifiers with "{id}". A segment
+// is considered an identifier if it contains a digit and consists only of hexadecimal digits and
+// dashes, which covers numeric IDs, hashes, and UUIDs.
+func SanitizePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if looksLikeID(s) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// looksLikeID returns true if the path segment looks like an identifier.
+func looksLikeID(s string) bool {
+	hasDigit := false
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			hasDigit = true
+		case r >= 'a' && r <= 'f', r >= 'A' && r <= 'F', r == '-':
+		default:
+			return false
+		}
+	}
+	return hasDigit
+}
+
+// responseRecorder captures the status code and the size of the response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	size        int
+	wroteHeader bool
+}
+
+// WriteHeader records the status code and passes it on.
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+// Write counts the bytes of the body and passes them on.
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	n, err := rr.ResponseWriter.Write(b)
+	rr.size += n
+	return n, err
+}
+
+// Flush passes the flush on if the underlying writer supports it.
+func (rr *responseRecorder) Flush() {
+	if f, ok := rr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, so that http.ResponseController can reach the features of it
+// that the recorder doesn't pass on, such as hijacking the connection.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
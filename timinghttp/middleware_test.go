@@ -0,0 +1,103 @@
+package timinghttp
+
+import (
+	"context"
+	timing "github.com/gburgyan/go-timing"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Middleware(t *testing.T) {
+	var completed *timing.Location
+	options := Options{
+		OnComplete: func(r *http.Request, root *timing.Location) {
+			completed = root
+		},
+	}
+
+	handler := options.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, complete := timing.Start(r.Context(), "db")
+		complete()
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("hello"))
+		w.(http.Flusher).Flush()
+	}))
+
+	req := httptest.NewRequest("POST", "/users/12345/posts/9f8e7d6c-0000-4000-8000-0123456789ab", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "hello", rec.Body.String())
+
+	assert.Equal(t, "POST /users/{id}/posts/{id}", completed.Name)
+	assert.Equal(t, uint64(1), completed.ExitCount)
+	assert.Equal(t, uint64(1), completed.Children["db"].ExitCount)
+	assert.Equal(t, http.StatusCreated, completed.Details["status"])
+	assert.Equal(t, 5, completed.Details["size"])
+}
+
+func Test_MiddlewareDefaults(t *testing.T) {
+	var tCtx context.Context
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tCtx = r.Context()
+	}))
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	root := tCtx.(*timing.Context)
+	assert.Equal(t, "GET /health", root.Name)
+	assert.Equal(t, http.StatusOK, root.Details["status"])
+	assert.Equal(t, 0, root.Details["size"])
+}
+
+func Test_MiddlewarePanic(t *testing.T) {
+	var completed *timing.Location
+	options := Options{
+		OnComplete: func(r *http.Request, root *timing.Location) {
+			completed = root
+		},
+	}
+
+	handler := options.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("boom")
+	}))
+
+	req := httptest.NewRequest("GET", "/panic", nil)
+	assert.PanicsWithValue(t, "boom", func() {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	})
+	assert.Equal(t, "GET /panic", completed.Name)
+	assert.Equal(t, uint64(1), completed.ExitCount)
+	assert.Equal(t, http.StatusAccepted, completed.Details["status"])
+
+	// A panic before the header is written isn't recorded as a success.
+	handler = options.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	assert.PanicsWithValue(t, "boom", func() {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	})
+	assert.Equal(t, http.StatusInternalServerError, completed.Details["status"])
+	assert.Equal(t, 0, completed.Details["size"])
+}
+
+func Test_MiddlewareUnwrap(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Same(t, rec, w.(interface{ Unwrap() http.ResponseWriter }).Unwrap())
+	}))
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+}
+
+func Test_SanitizePath(t *testing.T) {
+	assert.Equal(t, "/users/{id}", SanitizePath("/users/42"))
+	assert.Equal(t, "/files/{id}/raw", SanitizePath("/files/deadbeef01/raw"))
+	assert.Equal(t, "/users/me/feed", SanitizePath("/users/me/feed"))
+	assert.Equal(t, "/cafe/face", SanitizePath("/cafe/face"))
+	assert.Equal(t, "/", SanitizePath("/"))
+}
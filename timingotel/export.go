@@ -0,0 +1,87 @@
+// Package timingotel exports go-timing trees as OpenTelemetry spans.
+package timingotel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	timing "github.com/gburgyan/go-timing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ExportToSpan creates a span for every location in the timing tree, with the spans of the children
+// as children of the span of their parent. The root span is a child of any span that is in ctx. If the
+// root is an unnamed root, its children become the top-level spans instead.
+//
+// A timing tree only has the total duration of each location, not when each call happened, so the
+// spans are laid out from the durations: the root ends now, and the children of a location follow
+// one another from the start of their parent in the order that they were called. The children of an
+// Async location all start at the start of their parent instead, so that they overlap like the
+// concurrent work they timed did.
+//
+// The details of each location are attached as attributes, along with "timing.entry_count",
+// "timing.exit_count", and, for Async locations, "timing.async". The tree is exported from a Clone of
+// it, so this is safe to call while it is still being timed.
+func ExportToSpan(ctx context.Context, root *timing.Location, tracer trace.Tracer) {
+	root = root.Clone()
+	start := time.Now().Add(-root.Duration())
+	exportLocation(ctx, root, tracer, start)
+}
+
+// exportLocation creates the span of a location that started at start, along with the spans of its
+// descendants.
+func exportLocation(ctx context.Context, l *timing.Location, tracer trace.Tracer, start time.Time) {
+	childCtx := ctx
+	var span trace.Span
+	if l.Name != "" {
+		childCtx, span = tracer.Start(ctx, l.Name, trace.WithTimestamp(start), trace.WithAttributes(attributes(l)...))
+	}
+
+	childStart := start
+	for _, name := range l.CallOrder {
+		child := l.Children[name]
+		exportLocation(childCtx, child, tracer, childStart)
+		if !l.Async {
+			childStart = childStart.Add(child.Duration())
+		}
+	}
+
+	if span != nil {
+		span.End(trace.WithTimestamp(start.Add(l.Duration())))
+	}
+}
+
+// attributes returns the span attributes for a location.
+func attributes(l *timing.Location) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.Int64("timing.entry_count", int64(l.Entries())),
+		attribute.Int64("timing.exit_count", int64(l.Exits())),
+	}
+	if l.Async {
+		attrs = append(attrs, attribute.Bool("timing.async", true))
+	}
+	for k, v := range l.Details {
+		attrs = append(attrs, detailAttribute(k, v))
+	}
+	return attrs
+}
+
+// detailAttribute converts a detail to a span attribute, keeping its type where possible.
+func detailAttribute(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	default:
+		return attribute.String(key, fmt.Sprintf("%+v", v))
+	}
+}
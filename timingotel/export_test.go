@@ -0,0 +1,109 @@
+package timingotel
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	timing "github.com/gburgyan/go-timing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func Test_ExportToSpan(t *testing.T) {
+	rootCtx, complete := timing.Start(context.Background(), "root")
+	dbCtx, dbComplete := timing.Start(rootCtx, "db")
+	dbComplete()
+	workersCtx, workersComplete := timing.StartAsync(rootCtx, "workers")
+	for _, name := range []string{"worker 1", "worker 2"} {
+		_, workerComplete := timing.Start(workersCtx, name)
+		workerComplete()
+	}
+	workersComplete()
+	complete()
+
+	rootCtx.TotalDuration = 100 * time.Millisecond
+	dbCtx.TotalDuration = 40 * time.Millisecond
+	workersCtx.TotalDuration = 50 * time.Millisecond
+	workersCtx.Children["worker 1"].TotalDuration = 30 * time.Millisecond
+	workersCtx.Children["worker 2"].TotalDuration = 50 * time.Millisecond
+	dbCtx.AddDetails("rows", 12)
+	dbCtx.AddDetails("table", "users")
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ExportToSpan(context.Background(), rootCtx.Location, provider.Tracer("test"))
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	assert.Len(t, spans, 5)
+
+	root := spans["root"]
+	assert.False(t, root.Parent().IsValid())
+	assert.Equal(t, 100*time.Millisecond, root.EndTime().Sub(root.StartTime()))
+
+	db := spans["db"]
+	assert.Equal(t, root.SpanContext().SpanID(), db.Parent().SpanID())
+	assert.Equal(t, root.StartTime(), db.StartTime())
+	assert.Equal(t, 40*time.Millisecond, db.EndTime().Sub(db.StartTime()))
+	assert.Contains(t, db.Attributes(), attribute.Int("rows", 12))
+	assert.Contains(t, db.Attributes(), attribute.String("table", "users"))
+	assert.Contains(t, db.Attributes(), attribute.Int64("timing.exit_count", 1))
+
+	workers := spans["workers"]
+	assert.Equal(t, db.EndTime(), workers.StartTime())
+	assert.Contains(t, workers.Attributes(), attribute.Bool("timing.async", true))
+
+	// The children of an Async location overlap
+	for _, name := range []string{"worker 1", "worker 2"} {
+		assert.Equal(t, workers.SpanContext().SpanID(), spans[name].Parent().SpanID())
+		assert.Equal(t, workers.StartTime(), spans[name].StartTime())
+	}
+}
+
+func Test_ExportToSpanUnnamedRoot(t *testing.T) {
+	root := timing.Root(context.Background())
+	for _, name := range []string{"a", "b"} {
+		_, complete := timing.Start(root, name)
+		complete()
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ExportToSpan(context.Background(), root.Location, provider.Tracer("test"))
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	for _, s := range spans {
+		assert.False(t, s.Parent().IsValid())
+	}
+}
+
+func Test_ExportToSpanWhileRunning(t *testing.T) {
+	rootCtx, complete := timing.StartAsync(context.Background(), "root")
+	provider := sdktrace.NewTracerProvider()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				workerCtx, workerComplete := timing.Start(rootCtx, "worker "+strconv.Itoa(j%5))
+				workerCtx.AddDetails("iteration", j)
+				workerComplete()
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		ExportToSpan(context.Background(), rootCtx.Location, provider.Tracer("test"))
+	}
+	wg.Wait()
+	complete()
+}
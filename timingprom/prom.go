@@ -0,0 +1,84 @@
+// Package timingprom publishes go-timing trees as Prometheus metrics.
+package timingprom
+
+import (
+	timing "github.com/gburgyan/go-timing"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Exporter observes the locations of timing trees into a pair of Prometheus metrics that are labeled
+// with the path of each location:
+//
+//   - "<prefix>_duration_seconds" is a histogram of the duration of each location in each tree.
+//   - "<prefix>_calls_total" is a counter of the number of times each location was entered.
+//
+// The paths are the keys that ReportMap generates for the tree, so they are the names of the
+// locations joined with the Separator.
+type Exporter struct {
+	// Separator is used between the levels of the path label. If this is not specified the default
+	// is ".".
+	Separator string
+
+	// ExcludeChildren reports the duration of each location less that of its children, except for
+	// Async locations, in the same way as the excludeChildren parameter of ReportMap.
+	ExcludeChildren bool
+
+	durations *prometheus.HistogramVec
+	calls     *prometheus.CounterVec
+}
+
+// New creates an Exporter and registers its metrics with reg. The names of the metrics start with
+// prefix, e.g. "myapp_timing". An error is returned if the metrics can't be registered, such as if
+// another Exporter with the same prefix is already registered.
+func New(reg prometheus.Registerer, prefix string) (*Exporter, error) {
+	e := &Exporter{
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: prefix + "_duration_seconds",
+			Help: "Duration of each timed location.",
+		}, []string{"path"}),
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_calls_total",
+			Help: "Number of times each timed location was entered.",
+		}, []string{"path"}),
+	}
+	if err := reg.Register(e.durations); err != nil {
+		return nil, err
+	}
+	if err := reg.Register(e.calls); err != nil {
+		reg.Unregister(e.durations)
+		return nil, err
+	}
+	return e, nil
+}
+
+// Observe records the timings of a completed tree. Every location that has been entered adds one
+// observation of its duration to the histogram, and adds its entry count to the counter. This is
+// meant to be called once per tree, e.g. at the end of each request, so that the histogram shows the
+// distribution of the time each location takes per request. The tree is observed from a Clone of it,
+// so the counts and durations are consistent with each other even if it is still being timed.
+func (e *Exporter) Observe(root *timing.Location) {
+	root = root.Clone()
+	separator := e.Separator
+	if separator == "" {
+		separator = "."
+	}
+	counts := map[string]uint64{}
+	entryCounts(root, "", separator, counts)
+	for _, entry := range root.ReportSlice(separator, 1e9, e.ExcludeChildren) {
+		e.durations.WithLabelValues(entry.Key).Observe(entry.Value)
+		e.calls.WithLabelValues(entry.Key).Add(float64(counts[entry.Key]))
+	}
+}
+
+// entryCounts recursively collects the entry counts of a location and its descendants, keyed the same
+// way as ReportMap.
+func entryCounts(l *timing.Location, path, separator string, counts map[string]uint64) {
+	if l.Name != "" {
+		path += l.Name
+		counts[path] = l.Entries()
+		path += separator
+	}
+	for _, name := range l.CallOrder {
+		entryCounts(l.Children[name], path, separator, counts)
+	}
+}
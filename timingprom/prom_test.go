@@ -0,0 +1,106 @@
+package timingprom
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	timing "github.com/gburgyan/go-timing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Observe(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	e, err := New(reg, "test")
+	assert.NoError(t, err)
+	e.ExcludeChildren = true
+
+	rootCtx, complete := timing.Start(context.Background(), "root")
+	childCtx, childComplete := timing.Start(rootCtx, "child")
+	childComplete()
+	_, childComplete = timing.Start(rootCtx, "child")
+	childComplete()
+	complete()
+	rootCtx.TotalDuration = 3 * time.Second
+	childCtx.TotalDuration = 1 * time.Second
+
+	e.Observe(rootCtx.Location)
+
+	expected := `
+# HELP test_calls_total Number of times each timed location was entered.
+# TYPE test_calls_total counter
+test_calls_total{path="root"} 1
+test_calls_total{path="root.child"} 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "test_calls_total"))
+
+	expected = `
+# HELP test_duration_seconds Duration of each timed location.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{path="root",le="0.005"} 0
+test_duration_seconds_bucket{path="root",le="0.01"} 0
+test_duration_seconds_bucket{path="root",le="0.025"} 0
+test_duration_seconds_bucket{path="root",le="0.05"} 0
+test_duration_seconds_bucket{path="root",le="0.1"} 0
+test_duration_seconds_bucket{path="root",le="0.25"} 0
+test_duration_seconds_bucket{path="root",le="0.5"} 0
+test_duration_seconds_bucket{path="root",le="1"} 0
+test_duration_seconds_bucket{path="root",le="2.5"} 1
+test_duration_seconds_bucket{path="root",le="5"} 1
+test_duration_seconds_bucket{path="root",le="10"} 1
+test_duration_seconds_bucket{path="root",le="+Inf"} 1
+test_duration_seconds_sum{path="root"} 2
+test_duration_seconds_count{path="root"} 1
+test_duration_seconds_bucket{path="root.child",le="0.005"} 0
+test_duration_seconds_bucket{path="root.child",le="0.01"} 0
+test_duration_seconds_bucket{path="root.child",le="0.025"} 0
+test_duration_seconds_bucket{path="root.child",le="0.05"} 0
+test_duration_seconds_bucket{path="root.child",le="0.1"} 0
+test_duration_seconds_bucket{path="root.child",le="0.25"} 0
+test_duration_seconds_bucket{path="root.child",le="0.5"} 0
+test_duration_seconds_bucket{path="root.child",le="1"} 1
+test_duration_seconds_bucket{path="root.child",le="2.5"} 1
+test_duration_seconds_bucket{path="root.child",le="5"} 1
+test_duration_seconds_bucket{path="root.child",le="10"} 1
+test_duration_seconds_bucket{path="root.child",le="+Inf"} 1
+test_duration_seconds_sum{path="root.child"} 1
+test_duration_seconds_count{path="root.child"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "test_duration_seconds"))
+}
+
+func Test_New_DuplicatePrefix(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	_, err := New(reg, "test")
+	assert.NoError(t, err)
+	_, err = New(reg, "test")
+	assert.Error(t, err)
+}
+
+func Test_ObserveWhileRunning(t *testing.T) {
+	e, err := New(prometheus.NewRegistry(), "test")
+	assert.NoError(t, err)
+	rootCtx, complete := timing.StartAsync(context.Background(), "root")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, workerComplete := timing.Start(rootCtx, "worker "+strconv.Itoa(j%5))
+				workerComplete()
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		e.Observe(rootCtx.Location)
+	}
+	wg.Wait()
+	complete()
+}
@@ -0,0 +1,99 @@
+// Package timingstatsd exports go-timing trees to a StatsD or DogStatsD server.
+package timingstatsd
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	timing "github.com/gburgyan/go-timing"
+)
+
+// Exporter sends a timing metric for every location of a timing tree to a StatsD server.
+type Exporter struct {
+	// Prefix is prepended to every metric name. If empty, the metric names start with the name of
+	// the root location.
+	Prefix string
+
+	// Separator is used between the levels of the metric name. If this is not specified the
+	// default is ".".
+	Separator string
+
+	conn io.Writer
+}
+
+// New creates an Exporter that sends metrics over UDP to the StatsD server at addr, e.g.
+// "localhost:8125".
+func New(addr string) (*Exporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithWriter(conn), nil
+}
+
+// NewWithWriter creates an Exporter that writes each metric to w as a separate write. This allows
+// the metrics to be sent over any transport.
+func NewWithWriter(w io.Writer) *Exporter {
+	return &Exporter{
+		conn: w,
+	}
+}
+
+// Close closes the underlying connection if it can be closed.
+func (e *Exporter) Close() error {
+	if c, ok := e.conn.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Export sends a timing metric, in milliseconds, for every location in the tree that has been
+// entered. Each location name is sanitized so that it is a valid metric name segment. For example
+// a location "child 1" under "root" with a prefix of "timing" is sent as
+// "timing.root.child_1:100|ms". The first write error that is encountered is returned. The tree is
+// exported from a Clone of it, so this is safe to call while it is still being timed.
+func (e *Exporter) Export(root *timing.Location) error {
+	separator := e.Separator
+	if separator == "" {
+		separator = "."
+	}
+	return e.export(root.Clone(), e.Prefix, separator)
+}
+
+// export recursively sends the metrics for a location and its children.
+func (e *Exporter) export(l *timing.Location, path, separator string) error {
+	if l.Name != "" {
+		if path != "" {
+			path += separator
+		}
+		path += Sanitize(l.Name)
+		if l.Entries() > 0 {
+			ms := float64(l.Duration()) / 1e6
+			line := path + ":" + strconv.FormatFloat(ms, 'f', -1, 64) + "|ms"
+			if _, err := e.conn.Write([]byte(line)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range l.CallOrder {
+		if err := e.export(l.Children[name], path, separator); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sanitize converts a location name into a valid metric name segment. Letters, digits, '-' and '_'
+// are kept and everything else is replaced by '_'.
+func Sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
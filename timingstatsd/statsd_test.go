@@ -0,0 +1,97 @@
+package timingstatsd
+
+import (
+	"context"
+	timing "github.com/gburgyan/go-timing"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Export(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	exporter, err := New(listener.LocalAddr().String())
+	assert.NoError(t, err)
+	defer exporter.Close()
+	exporter.Prefix = "timing"
+
+	rootCtx, rootComplete := timing.Start(context.Background(), "root")
+	child1Ctx, c1Complete := timing.Start(rootCtx, "child 1")
+	c1Complete()
+	child2Ctx, c2Complete := timing.Start(rootCtx, "db:query")
+	c2Complete()
+	rootComplete()
+	rootCtx.TotalDuration = 210 * time.Millisecond
+	child1Ctx.TotalDuration = 100 * time.Millisecond
+	child2Ctx.TotalDuration = 1500 * time.Microsecond
+
+	assert.NoError(t, exporter.Export(rootCtx.Location))
+
+	var lines []string
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, listener.SetReadDeadline(time.Now().Add(time.Second)))
+		n, _, err := listener.ReadFrom(buf)
+		assert.NoError(t, err)
+		lines = append(lines, string(buf[:n]))
+	}
+
+	assert.Equal(t, []string{
+		"timing.root:210|ms",
+		"timing.root.child_1:100|ms",
+		"timing.root.db_query:1.5|ms",
+	}, lines)
+}
+
+func Test_ExportSeparator(t *testing.T) {
+	root := timing.Root(context.Background())
+	childCtx, complete := timing.Start(root, "child")
+	complete()
+	childCtx.TotalDuration = 5 * time.Millisecond
+	timing.ForName(childCtx, "unstarted")
+
+	var w recorder
+	exporter := NewWithWriter(&w)
+	exporter.Separator = "/"
+	assert.NoError(t, exporter.Export(root.Location))
+	assert.Equal(t, []string{"child:5|ms"}, w.lines)
+	assert.NoError(t, exporter.Close())
+}
+
+type recorder struct {
+	lines []string
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.lines = append(r.lines, string(p))
+	return len(p), nil
+}
+
+func Test_ExportWhileRunning(t *testing.T) {
+	exporter := NewWithWriter(io.Discard)
+	rootCtx, complete := timing.StartAsync(context.Background(), "root")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, workerComplete := timing.Start(rootCtx, "worker "+strconv.Itoa(j%5))
+				workerComplete()
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, exporter.Export(rootCtx.Location))
+	}
+	wg.Wait()
+	complete()
+}
@@ -0,0 +1,439 @@
+package timing
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Since returns a copy of this tree that only contains the locations that were first started after
+// the cutoff. This is useful for reporting on a recent window of a long-lived tree, such as one that
+// tracks the work done over a persistent connection.
+//
+// The location this is called on is always returned, with its timings cleared if it was started
+// before the cutoff. Locations that were started before the cutoff, but that have descendants that
+// were started after it, are kept as empty placeholders so that the paths of the descendants are
+// preserved. Since only the time of the first start of each location is known, the timings of the
+// locations that are kept are copied in full.
+func (l *Location) Since(cutoff time.Time) *Location {
+	result := l.sinceCutoff(cutoff.UnixNano())
+	if result == nil {
+		result = &Location{
+			Name:  l.Name,
+			Async: l.isAsync(),
+		}
+	}
+	return result
+}
+
+// sinceCutoff recursively copies the locations that were first started after the cutoff. This
+// returns nil if neither the location nor any of its descendants qualify.
+func (l *Location) sinceCutoff(cutoff int64) *Location {
+	var result *Location
+	first := atomic.LoadInt64(&l.firstEntry)
+	if first != 0 && first > cutoff {
+		result = l.copyNode()
+	}
+	for _, child := range l.snapshotChildren() {
+		cc := child.sinceCutoff(cutoff)
+		if cc == nil {
+			continue
+		}
+		if result == nil {
+			result = &Location{
+				Name:  l.Name,
+				Async: l.isAsync(),
+			}
+		}
+		result.addChild(cc)
+	}
+	return result
+}
+
+// Expire removes the descendants of this location that have not been started within olderThan of
+// now. This bounds the size of long-lived trees where the names of the locations come and go, such
+// as one that tracks the timings per endpoint. A location that hasn't been started recently is
+// kept if any of its descendants have been, so that their paths are preserved. The location that
+// this is called on is never removed.
+func (l *Location) Expire(olderThan time.Duration, now time.Time) {
+	l.expire(now.Add(-olderThan).UnixNano())
+}
+
+// expire recursively removes the stale children of this location. It returns true if this location
+// or any of its remaining descendants have been started since the cutoff.
+func (l *Location) expire(cutoff int64) bool {
+	fresh := atomic.LoadInt64(&l.lastEntry) >= cutoff
+	for _, child := range l.snapshotChildren() {
+		if child.expire(cutoff) {
+			fresh = true
+			continue
+		}
+		l.removeChild(child.Name)
+	}
+	return fresh
+}
+
+// Prune removes the descendants of this location that have never been started and that don't have
+// any descendants that have been. These are left behind by ForName when the timing context it returns
+// is never started, and show up as empty lines in the reports. Placeholders whose descendants have
+// been started, such as those for grouping Async tasks, are kept. The location that this is called on
+// is never removed. See ReportOptions.HideUnentered to leave them out of a report instead.
+func (l *Location) Prune() {
+	l.prune()
+}
+
+// prune recursively removes the children of this location that have never been entered. It returns
+// true if this location or any of its remaining descendants have been entered.
+func (l *Location) prune() bool {
+	entered := l.Entries() > 0
+	for _, child := range l.snapshotChildren() {
+		if child.prune() {
+			entered = true
+			continue
+		}
+		l.removeChild(child.Name)
+	}
+	return entered
+}
+
+// entered returns true if this location or any of its descendants have been entered.
+func (l *Location) entered() bool {
+	if l.Entries() > 0 {
+		return true
+	}
+	for _, child := range l.snapshotChildren() {
+		if child.entered() {
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a deep copy of this tree, with copies of the children, details, sections, and call
+// order of every location. This allows a tree that is still being timed to be reported on or
+// marshaled without any further locking, and without the numbers changing part way through. Each
+// location is copied under its lock, so a timing that completes while the tree is being cloned is
+// reflected in either all or none of the copied fields of its location. The copy is a root, even if
+// this location is not.
+func (l *Location) Clone() *Location {
+	result := l.copyNode()
+	for _, child := range l.snapshotChildren() {
+		result.addChild(child.Clone())
+	}
+	return result
+}
+
+// Reset clears the timings of this location and all of its descendants so that the tree can be
+// reused, such as for the next batch of a long-running worker once the previous one has been
+// reported. The structure of the tree is kept: the locations keep their names, children, call order,
+// notes and settings, like Async and RecordSamples, so the reports of successive batches line up.
+// Their counts, durations, details, sections, samples and histogram counts are cleared. Use Expire
+// to remove the locations that are no longer used.
+//
+// This is meant to be called between batches. A timing that is in progress while the tree is reset
+// is counted as an exit without a matching entry when it completes.
+func (l *Location) Reset() {
+	if l.isDisabled() {
+		return
+	}
+	for _, child := range l.snapshotChildren() {
+		child.Reset()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	atomic.StoreUint64(&l.EntryCount, 0)
+	atomic.StoreUint64(&l.ExitCount, 0)
+	atomic.StoreInt64((*int64)(&l.TotalDuration), 0)
+	atomic.StoreInt64((*int64)(&l.MinDuration), 0)
+	atomic.StoreInt64((*int64)(&l.MaxDuration), 0)
+	atomic.StoreInt64(&l.firstEntry, 0)
+	atomic.StoreInt64(&l.lastEntry, 0)
+	atomic.StoreInt64(&l.lastExit, 0)
+	atomic.StoreUint64(&l.cancelled, 0)
+	atomic.StoreUint64(&l.deadlineExceeded, 0)
+	l.Details = nil
+	l.detailOrder = nil
+	l.Sections = nil
+	l.sectionOrder = nil
+	l.samples = l.samples[:0]
+	l.sampleNext = 0
+	if l.Histogram != nil {
+		l.Histogram.Counts = make([]uint64, len(l.Histogram.Counts))
+	}
+}
+
+// HotTree returns a copy of this tree that only contains the locations that together account for the
+// cumulativeFraction of the time spent, e.g. 0.95 for 95%, dropping the long tail of locations that
+// don't matter. The locations are taken in order of descending self-time, which is their
+// SelfDuration, until their combined self-time reaches the fraction of the combined
+// self-time of the whole tree. The ancestors of the locations that are taken are always kept so that
+// their paths are preserved, as is the location this is called on.
+func (l *Location) HotTree(cumulativeFraction float64) *Location {
+	var nodes []*Location
+	var collect func(n *Location)
+	collect = func(n *Location) {
+		nodes = append(nodes, n)
+		for _, child := range n.snapshotChildren() {
+			collect(child)
+		}
+	}
+	collect(l)
+
+	selfTimes := make(map[*Location]time.Duration, len(nodes))
+	var total time.Duration
+	for _, n := range nodes {
+		self := n.SelfDuration()
+		selfTimes[n] = self
+		total += self
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return selfTimes[nodes[i]] > selfTimes[nodes[j]]
+	})
+
+	keep := map[*Location]bool{l: true}
+	target := cumulativeFraction * float64(total)
+	var cumulative time.Duration
+	for _, n := range nodes {
+		if float64(cumulative) >= target {
+			break
+		}
+		cumulative += selfTimes[n]
+		for a := n; a != nil && !keep[a]; a = a.parent {
+			keep[a] = true
+		}
+	}
+	return l.copyKept(keep)
+}
+
+// copyKept recursively copies this location along with the descendants that are to be kept.
+func (l *Location) copyKept(keep map[*Location]bool) *Location {
+	result := l.copyNode()
+	for _, child := range l.snapshotChildren() {
+		if keep[child] {
+			result.addChild(child.copyKept(keep))
+		}
+	}
+	return result
+}
+
+// GroupChildren returns a copy of this tree where the children of every location are grouped by the
+// key that keyFn returns for their names, such as "user" for "user:1" and "user:2". The children in
+// each group are merged into one location named by the key, like Merge does, so their counts and
+// durations are summed and their own children are matched by name. If keyFn returns an empty string
+// the child keeps its own name. This keeps trees with many uniquely named locations readable without
+// changing how they are timed. The groups are in the order that the first child of each was called in.
+func (l *Location) GroupChildren(keyFn func(name string) string) *Location {
+	result := l.copyNode()
+	for _, child := range l.snapshotChildren() {
+		grouped := child.GroupChildren(keyFn)
+		if key := keyFn(child.Name); key != "" {
+			grouped.Name = key
+		}
+		if existing, ok := result.Children[grouped.Name]; ok {
+			existing.Merge(grouped)
+		} else {
+			result.addChild(grouped)
+		}
+	}
+	return result
+}
+
+// Merge adds the timings of the other tree to this one. This is useful to combine trees that were
+// timed independently, such as ones started with StartRoot on separate Goroutines, into one report.
+// The names of the two locations don't need to match, but their descendants are matched by name:
+//
+//   - The counts and durations of matching locations are added together, and their fastest and slowest
+//     calls are combined.
+//   - Children that are only in the other tree are copied and added after the existing children.
+//   - Details that are only in the other tree are added. If both have the same detail, the value from
+//     the other tree wins.
+//   - Sections are added together, a location is Async if either is, and a note is only taken from the
+//     other tree if this one doesn't have one.
+//   - Histograms are added together if they have the same buckets. Otherwise, the histogram of this
+//     tree is kept, and that of the other tree is only taken if this one doesn't have one.
+//
+// The other tree is not modified.
+func (l *Location) Merge(other *Location) {
+	if l.isDisabled() {
+		return
+	}
+	o := other.copyNode()
+
+	atomic.AddUint64(&l.EntryCount, o.EntryCount)
+	atomic.AddUint64(&l.ExitCount, o.ExitCount)
+	atomic.AddInt64((*int64)(&l.TotalDuration), int64(o.TotalDuration))
+	if o.MinDuration > 0 {
+		l.updateMinMax(o.MinDuration)
+	}
+	l.updateMinMax(o.MaxDuration)
+	if o.firstEntry != 0 {
+		for {
+			first := atomic.LoadInt64(&l.firstEntry)
+			if first != 0 && first <= o.firstEntry || atomic.CompareAndSwapInt64(&l.firstEntry, first, o.firstEntry) {
+				break
+			}
+		}
+	}
+	for {
+		last := atomic.LoadInt64(&l.lastEntry)
+		if last >= o.lastEntry || atomic.CompareAndSwapInt64(&l.lastEntry, last, o.lastEntry) {
+			break
+		}
+	}
+	l.updateLastExit(o.lastExit)
+	atomic.AddUint64(&l.cancelled, o.cancelled)
+	atomic.AddUint64(&l.deadlineExceeded, o.deadlineExceeded)
+
+	l.mu.Lock()
+	if o.Async {
+		l.Async = true
+	}
+	if l.NoteText == "" {
+		l.NoteText = o.NoteText
+	}
+	for _, k := range o.sectionOrder {
+		if l.Sections == nil {
+			l.Sections = map[string]time.Duration{}
+		}
+		if _, ok := l.Sections[k]; !ok {
+			l.sectionOrder = append(l.sectionOrder, k)
+		}
+		l.Sections[k] += o.Sections[k]
+	}
+	if o.Histogram != nil {
+		if l.Histogram == nil {
+			l.Histogram = o.Histogram
+			atomic.StoreInt32(&l.histogramEnabled, 1)
+		} else {
+			l.Histogram.merge(o.Histogram)
+		}
+	}
+	l.mu.Unlock()
+	for _, k := range o.detailKeys(true) {
+		l.AddDetails(k, o.Details[k])
+	}
+
+	for _, oc := range other.snapshotChildren() {
+		l.mu.RLock()
+		c, ok := l.Children[oc.Name]
+		l.mu.RUnlock()
+		if ok {
+			c.Merge(oc)
+		} else {
+			c = &Location{Name: oc.Name}
+			c.Merge(oc)
+			l.addChild(c)
+		}
+	}
+}
+
+// removeChild removes the named child of this location.
+func (l *Location) removeChild(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.Children, name)
+	for i, n := range l.CallOrder {
+		if n == name {
+			l.CallOrder = append(l.CallOrder[:i:i], l.CallOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// copyNode makes a copy of this location without any of its children.
+func (l *Location) copyNode() *Location {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	c := &Location{
+		Name:             l.Name,
+		EntryCount:       atomic.LoadUint64(&l.EntryCount),
+		ExitCount:        atomic.LoadUint64(&l.ExitCount),
+		TotalDuration:    l.Duration(),
+		MinDuration:      l.MinCall(),
+		MaxDuration:      l.MaxCall(),
+		Async:            l.Async,
+		NoteText:         l.NoteText,
+		firstEntry:       atomic.LoadInt64(&l.firstEntry),
+		lastEntry:        atomic.LoadInt64(&l.lastEntry),
+		lastExit:         atomic.LoadInt64(&l.lastExit),
+		cancelled:        atomic.LoadUint64(&l.cancelled),
+		deadlineExceeded: atomic.LoadUint64(&l.deadlineExceeded),
+		origin:           l.origin,
+	}
+	if l.Sections != nil {
+		c.Sections = make(map[string]time.Duration, len(l.Sections))
+		for k, v := range l.Sections {
+			c.Sections[k] = v
+		}
+		c.sectionOrder = append([]string(nil), l.sectionOrder...)
+	}
+	if l.Details != nil {
+		c.Details = make(map[string]anything, len(l.Details))
+		for k, v := range l.Details {
+			c.Details[k] = v
+		}
+		c.detailOrder = append([]string(nil), l.detailOrder...)
+	}
+	if l.Histogram != nil {
+		c.Histogram = l.Histogram.clone()
+		c.histogramEnabled = 1
+	}
+	return c
+}
+
+// Walk calls fn for this location and all of its descendants, depth first, with the children of each
+// location in the order that they were called in. The path has the names of the location and its
+// ancestors, from this location down, leaving out this location if it is an unnamed root. If fn returns
+// false the descendants of that location are skipped.
+//
+// The locations are the live ones, not copies. The children of each location are read under its lock
+// just before they are visited, but no lock is held while fn is called, so fn may call any of the
+// methods of the location. If the tree is still being timed, use the accessors, such as Duration and
+// Exits, to read the timings, and expect children that are added during the walk to be missed. The
+// path is reused between calls, so it must be copied if it is to be kept.
+func (l *Location) Walk(fn func(path []string, loc *Location) bool) {
+	var path []string
+	if l.Name != "" {
+		path = append(path, l.Name)
+	}
+	l.walk(path, fn)
+}
+
+// walk recursively visits the location, whose path is given, and its descendants.
+func (l *Location) walk(path []string, fn func(path []string, loc *Location) bool) {
+	if !fn(path, l) {
+		return
+	}
+	for _, child := range l.snapshotChildren() {
+		child.walk(append(path, child.Name), fn)
+	}
+}
+
+// snapshotChildren returns the children of this location in call order.
+func (l *Location) snapshotChildren() []*Location {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	children := make([]*Location, 0, len(l.CallOrder))
+	for _, name := range l.CallOrder {
+		children = append(children, l.Children[name])
+	}
+	return children
+}
+
+// addChild adds an existing location as the last child of this location.
+func (l *Location) addChild(c *Location) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.Children == nil {
+		l.Children = map[string]*Location{}
+	}
+	c.parent = l
+	l.Children[c.Name] = c
+	l.CallOrder = append(l.CallOrder, c.Name)
+}
@@ -0,0 +1,17 @@
+package timing
+
+import "context"
+
+// TimeValue times a call to fn as a child timing context named name, and returns the value and
+// error that fn produces. This avoids having to capture the value in a closure to use Time or
+// TimeErr. Like TimeErr, the message of any error is recorded as the "error" detail, and the timing
+// is completed even if fn panics.
+func TimeValue[T any](ctx context.Context, name string, fn func() (T, error)) (T, error) {
+	var value T
+	err := TimeErr(ctx, name, func() error {
+		var err error
+		value, err = fn()
+		return err
+	})
+	return value, err
+}